@@ -2,14 +2,83 @@
 package bash
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	migratedriver "github.com/promoboxx/migrate/driver"
 	"github.com/promoboxx/migrate/file"
-	_ "github.com/promoboxx/migrate/migrate/direction"
+	"github.com/promoboxx/migrate/migrate/direction"
 )
 
 type Driver struct {
+	// interpreter and its arguments, the script is passed on stdin
+	interpreter []string
+
+	// stateFile holds one applied version per line, followed by its
+	// metadata in tab separated fields
+	stateFile string
+}
+
+const (
+	defaultInterpreter = "bash"
+	killWaitDelay      = 2 * time.Second
+	// longest output line streamed back from a script
+	maxOutputLine = 16 * 1024 * 1024
+)
+
+func init() {
+	migratedriver.Register("bash", func(migratedriver.TxnType) migratedriver.Driver {
+		return &Driver{}
+	})
 }
 
+// Bash Driver URL format:
+// bash://path/to/statefile?interpreter=bash
+//
+// Example:
+// bash://./migrations.state
+// bash:///var/lib/app/migrations.state?interpreter=sh+-e
 func (driver *Driver) Initialize(url string) error {
+	return driver.InitializeContext(context.Background(), url)
+}
+
+func (driver *Driver) InitializeContext(ctx context.Context, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+
+	driver.stateFile = u.Host + u.Path
+	if driver.stateFile == "" {
+		return errors.New("missing state file in bash url")
+	}
+
+	interpreter := u.Query().Get("interpreter")
+	if interpreter == "" {
+		interpreter = defaultInterpreter
+	}
+	driver.interpreter = strings.Fields(interpreter)
+
+	if err := driver.ensureStateFileExists(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -17,16 +86,258 @@ func (driver *Driver) Close() error {
 	return nil
 }
 
+func (driver *Driver) ensureStateFileExists() error {
+	f, err := os.OpenFile(driver.stateFile, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
 func (driver *Driver) FilenameExtension() string {
 	return "sh"
 }
 
 func (driver *Driver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext kills the script once ctx is done.
+func (driver *Driver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
-	return
+
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+
+	started := time.Now()
+	if err := driver.run(ctx, f, pipe); err != nil {
+		pipe <- err
+		return
+	}
+
+	// Don't update the version number to count always run files
+	if f.Always == false {
+		if err := updateState(driver.stateFile, f, started); err != nil {
+			pipe <- err
+			return
+		}
+	}
+}
+
+// run executes the script and streams its combined output line by line
+// into the pipe.
+func (driver *Driver) run(ctx context.Context, f file.File, pipe chan interface{}) error {
+	cmd := exec.CommandContext(ctx, driver.interpreter[0], driver.interpreter[1:]...)
+	// Children of a killed script may keep the output open.
+	cmd.WaitDelay = killWaitDelay
+	cmd.Stdin = bytes.NewReader(f.Content)
+	if info, err := os.Stat(f.Path); err == nil && info.IsDir() {
+		cmd.Dir = f.Path
+	}
+
+	dir := "up"
+	if f.Direction == direction.Down {
+		dir = "down"
+	}
+	cmd.Env = append(os.Environ(),
+		"MIGRATE_VERSION="+strconv.FormatUint(f.Version, 10),
+		"MIGRATE_DIRECTION="+dir,
+		"MIGRATE_NAME="+f.Name,
+	)
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 64*1024), maxOutputLine)
+		for scanner.Scan() {
+			pipe <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			pipe <- fmt.Errorf("reading output of %s: %v", f.FileName, err)
+		}
+		// keep draining so the script never blocks on a full pipe
+		io.Copy(ioutil.Discard, pr)
+	}()
+
+	err := cmd.Run()
+	pw.Close()
+	<-done
+
+	return scriptError(ctx, f, err)
+}
+
+// scriptError describes why the script f failed, if it did. The code is
+// the exit status of the interpreter.
+func scriptError(ctx context.Context, f file.File, err error) error {
+	if ctx.Err() != nil {
+		return migratedriver.NewMigrationError(f, ctx.Err())
+	}
+	if err == nil {
+		return nil
+	}
+	e := migratedriver.NewMigrationError(f, err)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		e.Code = strconv.Itoa(exitErr.ExitCode())
+	}
+	return e
 }
 
 func (driver *Driver) Version() (uint64, error) {
-	return uint64(0), nil
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *Driver) VersionContext(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	versions, err := readState(driver.stateFile)
+	if err != nil {
+		return 0, err
+	}
+	var version uint64
+	for v := range versions {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// History returns the versions in the state file.
+func (driver *Driver) History(ctx context.Context) ([]migratedriver.AppliedMigration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return history(driver.stateFile)
+}
+
+func history(stateFile string) ([]migratedriver.AppliedMigration, error) {
+	versions, err := readState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]migratedriver.AppliedMigration, 0, len(versions))
+	for _, m := range versions {
+		applied = append(applied, m)
+	}
+	sort.Slice(applied, func(i, j int) bool { return applied[i].Version < applied[j].Version })
+	return applied, nil
+}
+
+// updateState records the applied up file f, or forgets the version of
+// the down file f.
+func updateState(stateFile string, f file.File, started time.Time) error {
+	versions, err := readState(stateFile)
+	if err != nil {
+		return err
+	}
+	if f.Direction == direction.Up {
+		versions[f.Version] = migratedriver.NewAppliedMigration(f, started)
+	} else if f.Direction == direction.Down {
+		delete(versions, f.Version)
+	}
+	return writeState(stateFile, versions)
+}
+
+// readState parses the state file. Lines written by older versions
+// hold the version only.
+func readState(stateFile string) (map[uint64]migratedriver.AppliedMigration, error) {
+	content, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[uint64]migratedriver.AppliedMigration)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		m, err := parseStateLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: unable to parse line %v: %v", stateFile, i+1, err)
+		}
+		versions[m.Version] = m
+	}
+	return versions, nil
+}
+
+func parseStateLine(line string) (migratedriver.AppliedMigration, error) {
+	var m migratedriver.AppliedMigration
+	fields := strings.Split(line, "\t")
+	v, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return m, err
+	}
+	m.Version = v
+	if len(fields) == 1 {
+		return m, nil
+	}
+	if len(fields) != 9 {
+		return m, fmt.Errorf("expected 9 fields, got %v", len(fields))
+	}
+	m.FileName, m.Name = fields[1], fields[2]
+	if m.AppliedAt, err = time.Parse(time.RFC3339Nano, fields[3]); err != nil {
+		return m, err
+	}
+	ms, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return m, err
+	}
+	m.Duration = time.Duration(ms) * time.Millisecond
+	m.Checksum, m.Host, m.User, m.ToolVersion = fields[5], fields[6], fields[7], fields[8]
+	return m, nil
+}
+
+func formatStateLine(m migratedriver.AppliedMigration) string {
+	if m.AppliedAt.IsZero() {
+		return strconv.FormatUint(m.Version, 10)
+	}
+	return strings.Join([]string{
+		strconv.FormatUint(m.Version, 10),
+		m.FileName,
+		m.Name,
+		m.AppliedAt.Format(time.RFC3339Nano),
+		strconv.FormatInt(m.DurationMs(), 10),
+		m.Checksum,
+		m.Host,
+		m.User,
+		m.ToolVersion,
+	}, "\t")
+}
+
+// writeState replaces the state file atomically.
+func writeState(stateFile string, versions map[uint64]migratedriver.AppliedMigration) error {
+	sorted := make([]uint64, 0, len(versions))
+	for v := range versions {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var buf bytes.Buffer
+	for _, v := range sorted {
+		buf.WriteString(formatStateLine(versions[v]) + "\n")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(stateFile), filepath.Base(stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), stateFile)
 }
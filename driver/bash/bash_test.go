@@ -1,9 +1,205 @@
 package bash
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/promoboxx/migrate/file"
+	"github.com/promoboxx/migrate/migrate/direction"
+	pipep "github.com/promoboxx/migrate/pipe"
 )
 
 func TestMigrate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := &Driver{}
+	if err := d.Initialize("bash://" + path.Join(tmpdir, "migrations.state") + "?interpreter=sh+-e"); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []file.File{
+		{
+			Path:      tmpdir,
+			FileName:  "001_foobar.up.sh",
+			Version:   1,
+			Name:      "foobar",
+			Direction: direction.Up,
+			Content: []byte(`
+				echo "$MIGRATE_VERSION $MIGRATE_DIRECTION $MIGRATE_NAME"
+				echo "to stderr" >&2
+				touch yolo
+			`),
+		},
+		{
+			Path:      tmpdir,
+			FileName:  "001_foobar.down.sh",
+			Version:   1,
+			Name:      "foobar",
+			Direction: direction.Down,
+			Content: []byte(`
+				rm yolo
+			`),
+		},
+		{
+			Path:      tmpdir,
+			FileName:  "002_foobar.up.sh",
+			Version:   2,
+			Name:      "foobar",
+			Direction: direction.Up,
+			Content: []byte(`
+				false
+				touch never
+			`),
+		},
+	}
+
+	pipe := pipep.New()
+	go d.Migrate(files[0], pipe)
+	output := []string{}
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			t.Fatal(item)
+		case string:
+			output = append(output, item)
+		}
+	}
+	if strings.Join(output, "\n") != "1 up foobar\nto stderr" {
+		t.Errorf("unexpected output %q", output)
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "yolo")); err != nil {
+		t.Error("script did not run in migrations path", err)
+	}
+	if version, err := d.Version(); err != nil || version != 1 {
+		t.Fatalf("Expected version 1, got %v (%v)", version, err)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate(files[2], pipe)
+	errs := pipep.ReadErrors(pipe)
+	if len(errs) == 0 {
+		t.Error("Expected test case to fail")
+	}
+	if version, err := d.Version(); err != nil || version != 1 {
+		t.Fatalf("Expected version 1, got %v (%v)", version, err)
+	}
+
+	pipe = pipep.New()
+	go d.Migrate(files[1], pipe)
+	errs = pipep.ReadErrors(pipe)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if version, err := d.Version(); err != nil || version != 0 {
+		t.Fatalf("Expected version 0, got %v (%v)", version, err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateContext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := &Driver{}
+	if err := d.Initialize("bash://" + path.Join(tmpdir, "migrations.state") + "?interpreter=sh"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	pipe := pipep.New()
+	go d.MigrateContext(ctx, file.File{Path: tmpdir, FileName: "001_sleep.up.sh", Version: 1, Direction: direction.Up, Content: []byte("sleep 10")}, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) == 0 {
+		t.Error("Expected test case to fail")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("script was not killed when the context expired")
+	}
+	if version, err := d.Version(); err != nil || version != 0 {
+		t.Fatalf("Expected version 0, got %v (%v)", version, err)
+	}
+}
+
+func TestMigrateLongOutput(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := &Driver{}
+	if err := d.Initialize("bash://" + path.Join(tmpdir, "migrations.state") + "?interpreter=sh"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a line longer than the default scanner buffer, then a short one
+	script := "head -c 100000 /dev/zero | tr '\\0' x; echo; echo done"
+	pipe := pipep.New()
+	go d.Migrate(file.File{Path: tmpdir, FileName: "001_long.up.sh", Version: 1, Direction: direction.Up, Content: []byte(script)}, pipe)
+	var lines []string
+	for item := range pipe {
+		switch v := item.(type) {
+		case error:
+			t.Fatal(v)
+		case string:
+			lines = append(lines, v)
+		}
+	}
+	if len(lines) != 2 || len(lines[0]) != 100000 || lines[1] != "done" {
+		t.Fatalf("Expected the long line and done, got %d lines", len(lines))
+	}
+}
+
+func TestStateFileMetadata(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// a state file as written by older versions
+	stateFile := path.Join(tmpdir, "migrations.state")
+	if err := ioutil.WriteFile(stateFile, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Driver{}
+	if err := d.Initialize("bash://" + stateFile + "?interpreter=sh"); err != nil {
+		t.Fatal(err)
+	}
+	f := file.File{Path: tmpdir, FileName: "002_foobar.up.sh", Version: 2, Name: "foobar", Direction: direction.Up, Content: []byte("true")}
+	pipe := pipep.New()
+	go d.Migrate(f, pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
 
+	versions, err := readState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || !versions[1].AppliedAt.IsZero() {
+		t.Fatalf("Expected old version 1 to be kept, got %v", versions)
+	}
+	m := versions[2]
+	if m.FileName != f.FileName || m.Name != f.Name || m.Checksum != f.Checksum() || m.AppliedAt.IsZero() || m.Host == "" {
+		t.Errorf("Unexpected metadata %+v", m)
+	}
 }
@@ -0,0 +1,179 @@
+package cassandra
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gocql/gocql"
+	migratedriver "github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+)
+
+// statement is a single statement of a migration file.
+type statement struct {
+	migratedriver.Statement
+
+	// keyword is the first word of Query in upper case, e.g. CREATE
+	keyword string
+}
+
+// splitStatements splits the content of a migration file into CQL
+// statements. Semicolons inside string literals, $$ strings, quoted
+// identifiers and --, // and /* */ comments are ignored, as are the
+// semicolons between BEGIN BATCH and APPLY BATCH. Statements that consist
+// of comments only are dropped.
+func splitStatements(content []byte) ([]statement, error) {
+	statements := make([]statement, 0)
+	start := 0
+	hasCode := false
+	// words holds the upper case keywords and identifiers of the current
+	// statement, to recognize batches
+	words := make([]string, 0)
+
+	flush := func(end int) {
+		if hasCode {
+			stmt := statement{Statement: migratedriver.NewStatement(content, start, end)}
+			if len(words) > 0 {
+				stmt.keyword = words[0]
+			}
+			statements = append(statements, stmt)
+		}
+		hasCode = false
+		words = words[:0]
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+
+		case c == ';':
+			if inBatch(words) {
+				i++
+				continue
+			}
+			flush(i)
+			i++
+			start = i
+
+		case c == '\'' || c == '"':
+			end, ok := quoteEnd(content, i)
+			if !ok {
+				return nil, migratedriver.SyntaxError(content, i, fmt.Sprintf("unterminated %c", c))
+			}
+			hasCode = true
+			i = end
+
+		case bytes.HasPrefix(content[i:], []byte("$$")):
+			end := bytes.Index(content[i+2:], []byte("$$"))
+			if end < 0 {
+				return nil, migratedriver.SyntaxError(content, i, "unterminated $$")
+			}
+			hasCode = true
+			i += 2 + end + 2
+
+		case bytes.HasPrefix(content[i:], []byte("--")) || bytes.HasPrefix(content[i:], []byte("//")):
+			i = migratedriver.LineEnd(content, i)
+
+		case bytes.HasPrefix(content[i:], []byte("/*")):
+			end := bytes.Index(content[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, migratedriver.SyntaxError(content, i, "unterminated comment")
+			}
+			i += 2 + end + 2
+
+		case isWordByte(c):
+			end := i
+			for end < len(content) && isWordByte(content[end]) {
+				end++
+			}
+			words = append(words, strings.ToUpper(string(content[i:end])))
+			hasCode = true
+			i = end
+
+		default:
+			hasCode = true
+			i++
+		}
+	}
+	if inBatch(words) {
+		offset := migratedriver.NewStatement(content, start, len(content)).Offset
+		return nil, migratedriver.SyntaxError(content, offset, "BEGIN BATCH without APPLY BATCH")
+	}
+	flush(len(content))
+	return statements, nil
+}
+
+// inBatch reports whether words start a batch that has not been applied
+// yet, i.e. BEGIN [UNLOGGED | COUNTER] BATCH without APPLY BATCH.
+func inBatch(words []string) bool {
+	if len(words) < 2 || words[0] != "BEGIN" {
+		return false
+	}
+	if words[1] != "BATCH" && (len(words) < 3 || words[2] != "BATCH") {
+		return false
+	}
+	n := len(words)
+	return n < 4 || words[n-2] != "APPLY" || words[n-1] != "BATCH"
+}
+
+// changesSchema reports whether stmt is a DDL statement, after which the
+// nodes of the cluster have to agree on the new schema.
+func (stmt statement) changesSchema() bool {
+	return stmt.keyword == "CREATE" || stmt.keyword == "ALTER" || stmt.keyword == "DROP"
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// quoteEnd returns the index after the string literal or quoted
+// identifier that starts at i. A doubled quote is read as two adjacent
+// quoted parts.
+func quoteEnd(content []byte, i int) (int, bool) {
+	end := bytes.IndexByte(content[i+1:], content[i])
+	if end < 0 {
+		return 0, false
+	}
+	return i + 1 + end + 1, true
+}
+
+// positionRegex matches the position Cassandra reports for syntax errors,
+// relative to the statement, with a 0-based column.
+var positionRegex = regexp.MustCompile(`line ([0-9]+):([0-9]+)`)
+
+// migrationError describes err, returned by the index-th statement stmt
+// of f. Cassandra errors carry a code and, for syntax errors, a position
+// that is mapped to the line and column in f. Anything else, e.g. a
+// cancelled context, is located at the start of stmt.
+func migrationError(f file.File, index int, stmt statement, err error) error {
+	e := migratedriver.NewMigrationError(f, err)
+	e.Statement = index + 1
+	offset := stmt.Offset
+	if reqErr, ok := err.(gocql.RequestError); ok {
+		e.Code = fmt.Sprintf("%#04x", reqErr.Code())
+		e.Message = reqErr.Message()
+	}
+	if m := positionRegex.FindStringSubmatch(e.Message); len(m) == 3 {
+		lineNo, _ := strconv.Atoi(m[1])
+		columnNo, _ := strconv.Atoi(m[2])
+		offset = stmt.Offset + columnOffset(stmt.Query, migratedriver.StatementOffset(stmt.Query, lineNo), columnNo)
+		// the position relative to the statement is replaced by the one in f
+		e.Message = strings.TrimSpace(positionRegex.ReplaceAllString(e.Message, ""))
+	}
+	e.SetPosition(f.Content, offset)
+	return e
+}
+
+// columnOffset moves offset, the start of a line in query, to the
+// 0-based columnNo if the line is long enough.
+func columnOffset(query string, offset, columnNo int) int {
+	if end := migratedriver.LineEnd([]byte(query), offset); offset+columnNo <= end {
+		offset += columnNo
+	}
+	return offset
+}
@@ -0,0 +1,111 @@
+package cassandra
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/promoboxx/migrate/file"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"CREATE TABLE a (id int PRIMARY KEY); CREATE TABLE b (id int PRIMARY KEY);", []string{"CREATE TABLE a (id int PRIMARY KEY)", "CREATE TABLE b (id int PRIMARY KEY)"}},
+		{"INSERT INTO a (id, s) VALUES (1, 'x;y''s;');", []string{"INSERT INTO a (id, s) VALUES (1, 'x;y''s;')"}},
+		{"CREATE TABLE \"a;b\" (id int PRIMARY KEY);", []string{"CREATE TABLE \"a;b\" (id int PRIMARY KEY)"}},
+		{"INSERT INTO a (id, s) VALUES (1, $$x;y$$);", []string{"INSERT INTO a (id, s) VALUES (1, $$x;y$$)"}},
+		{"-- comment; here\nSELECT 1 FROM a; // another; one\nSELECT 2 FROM a /* in; line */;", []string{"-- comment; here\nSELECT 1 FROM a", "// another; one\nSELECT 2 FROM a /* in; line */"}},
+		{"/* only a comment; */\n// and another\n", []string{}},
+		{
+			"BEGIN BATCH\n  INSERT INTO a (id) VALUES (1);\n  INSERT INTO a (id) VALUES (2);\nAPPLY BATCH;\nSELECT * FROM a;",
+			[]string{"BEGIN BATCH\n  INSERT INTO a (id) VALUES (1);\n  INSERT INTO a (id) VALUES (2);\nAPPLY BATCH", "SELECT * FROM a"},
+		},
+		{
+			"begin unlogged batch insert into a (id) values (1); apply batch",
+			[]string{"begin unlogged batch insert into a (id) values (1); apply batch"},
+		},
+		{"SELECT 1 FROM a", []string{"SELECT 1 FROM a"}},
+	}
+
+	for _, test := range tests {
+		statements, err := splitStatements([]byte(test.content))
+		if err != nil {
+			t.Errorf("%q: %v", test.content, err)
+			continue
+		}
+		queries := make([]string, 0)
+		for _, s := range statements {
+			queries = append(queries, s.Query)
+			if !strings.HasPrefix(test.content[s.Offset:], s.Query) {
+				t.Errorf("%q: wrong offset %v for %q", test.content, s.Offset, s.Query)
+			}
+		}
+		if strings.Join(queries, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%q: expected %q, got %q", test.content, test.expected, queries)
+		}
+	}
+}
+
+func TestChangesSchema(t *testing.T) {
+	statements, err := splitStatements([]byte("-- a table\ncreate table a (id int primary key);\nINSERT INTO a (id) VALUES (1);\n/* drop */ DROP TABLE a;\nALTER TABLE b ADD c int;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, false, true, true}
+	for i, stmt := range statements {
+		if stmt.changesSchema() != expected[i] {
+			t.Errorf("%q: expected %v", stmt.Query, expected[i])
+		}
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		message string
+	}{
+		{"SELECT 1 FROM a;\nSELECT 'abc;", "unterminated ' in line 2, column 8"},
+		{"SELECT 1 /* never closed", "unterminated comment in line 1, column 10"},
+		{"SELECT $$never closed", "unterminated $$ in line 1, column 8"},
+		{"SELECT 1 FROM a;\nBEGIN BATCH\nINSERT INTO a (id) VALUES (1);", "BEGIN BATCH without APPLY BATCH in line 2, column 1"},
+	}
+	for _, test := range tests {
+		_, err := splitStatements([]byte(test.content))
+		// the message is followed by the snippet around the position
+		if err == nil || !strings.HasPrefix(err.Error(), test.message+":\n") {
+			t.Errorf("%q: expected error %q, got %v", test.content, test.message, err)
+		}
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+	content := "CREATE TABLE a (id int PRIMARY KEY);\n\nCREATE TABLE b (\n  id THIS WILL FAIL\n);\nINSERT INTO c (id) VALUES (1);\n"
+	f := file.File{FileName: "001_a.up.cql", Content: []byte(content)}
+	stmts, err := splitStatements(f.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index  int
+		err    error
+		prefix string
+	}{
+		{1, errors.New("line 2:10 no viable alternative at input 'WILL'"),
+			"no viable alternative at input 'WILL' in line 4, column 11 (statement 2):"},
+		{2, errors.New("unconfigured table c"),
+			"unconfigured table c in line 6, column 1 (statement 3):"},
+	}
+	for _, test := range tests {
+		err := migrationError(f, test.index, stmts[test.index], test.err)
+		if !strings.HasPrefix(err.Error(), test.prefix) {
+			t.Errorf("expected prefix %q, got %q", test.prefix, err.Error())
+		}
+		if !strings.Contains(err.Error(), "CREATE TABLE b (") {
+			t.Errorf("expected the snippet in %q", err.Error())
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package driver
+
+import "github.com/promoboxx/migrate/file"
+
+// DirectiveValidator is implemented by drivers that honour some of the
+// header directives of migration files, see file.Directives. Drivers
+// that do not implement it support none of them.
+type DirectiveValidator interface {
+	// ValidateDirectives returns an error if d cannot be honoured.
+	ValidateDirectives(d file.Directives) error
+}
+
+// ReadDirectives parses the directives of f and checks that d supports
+// them. The content of f must have been read. Errors are returned as
+// *MigrationError.
+func ReadDirectives(d Driver, f file.File) (file.Directives, error) {
+	directives, err := f.Directives()
+	if err == nil {
+		if v, ok := Unwrap(d).(DirectiveValidator); ok {
+			err = v.ValidateDirectives(directives)
+		} else {
+			err = directives.CheckSupported()
+		}
+	}
+	if err != nil {
+		return file.Directives{}, NewMigrationError(f, err)
+	}
+	return directives, nil
+}
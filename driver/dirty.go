@@ -0,0 +1,29 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+)
+
+// DirtyTracker is implemented by drivers that flag a version as dirty
+// while its migration runs, so that a migration which failed part way
+// is not mistaken for an applied or a rolled back one.
+type DirtyTracker interface {
+	// Dirty returns the version whose migration started but never finished.
+	Dirty(ctx context.Context) (version uint64, dirty bool, err error)
+
+	// Force sets the current version and clears the dirty flag.
+	// Versions above version are forgotten. It is meant to be run
+	// after the database has been repaired by hand.
+	Force(ctx context.Context, version uint64) error
+}
+
+// DirtyError is returned when a run is attempted against a dirty database.
+type DirtyError struct {
+	Version uint64
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("database is dirty at version %v: a migration failed part way. "+
+		"Repair the database by hand, then run 'migrate force <version>' with the version it is now at.", e.Version)
+}
@@ -1,17 +1,21 @@
 // Package driver holds the driver interface.
+//
+// Drivers register themselves for a url scheme from their init function,
+// so a driver becomes available to New simply by importing its package:
+//
+//	import _ "github.com/promoboxx/migrate/driver/postgres"
+//
+// The drivers name their method receivers driver, which shadows this
+// package inside the methods, so they import it as migratedriver.
 package driver
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
-	neturl "net/url"
+	neturl "net/url" // alias to allow `url string` func signature in New
 	"strings"
 
-	"github.com/promoboxx/migrate/driver/bash"
-	"github.com/promoboxx/migrate/driver/cassandra"
-	"github.com/promoboxx/migrate/driver/mysql" // alias to allow `url string` func signature in New
-	"github.com/promoboxx/migrate/driver/postgres"
 	"github.com/promoboxx/migrate/file"
 )
 
@@ -21,6 +25,11 @@ const (
 	TxnNone TxnType = iota
 	TxnPerFile
 	TxnSingle
+
+	// TxnSinglePartial runs all files in a single transaction like
+	// TxnSingle, but commits the files before a failing one. Drivers
+	// without support fall back to TxnPerFile, which keeps them as well.
+	TxnSinglePartial
 )
 
 // Driver is the interface type that needs to implemented by all drivers.
@@ -49,61 +58,98 @@ type Driver interface {
 	Version() (uint64, error)
 }
 
-// New returns Driver and calls Initialize on it
+// ContextDriver is implemented by drivers that support cancellation and
+// deadlines. Drivers that only implement Driver are adapted with WithContext.
+type ContextDriver interface {
+	Driver
+
+	// InitializeContext is Initialize with a context that bounds
+	// opening and verifying the connection. Anything that lives on
+	// after initialization, like the transaction of a single
+	// transaction driver, must not be bound to ctx.
+	InitializeContext(ctx context.Context, url string) error
+
+	// MigrateContext is Migrate with a context. Once ctx is done the
+	// driver should abort the running migration, roll back what it can
+	// and send the resulting error to the pipe.
+	MigrateContext(ctx context.Context, file file.File, pipe chan interface{})
+
+	// VersionContext is Version with a context.
+	VersionContext(ctx context.Context) (uint64, error)
+}
+
+// WithContext adapts d to the ContextDriver interface. Drivers that
+// implement it already are returned unchanged. For all others the
+// context is checked before each call, but a running call cannot be
+// interrupted.
+func WithContext(d Driver) ContextDriver {
+	if cd, ok := d.(ContextDriver); ok {
+		return cd
+	}
+	return &contextAdapter{d}
+}
+
+type contextAdapter struct {
+	Driver
+}
+
+func (a *contextAdapter) InitializeContext(ctx context.Context, url string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Initialize(url)
+}
+
+func (a *contextAdapter) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
+	if err := ctx.Err(); err != nil {
+		pipe <- f
+		pipe <- err
+		close(pipe)
+		return
+	}
+	a.Migrate(f, pipe)
+}
+
+func (a *contextAdapter) VersionContext(ctx context.Context) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return a.Version()
+}
+
+// New looks up the driver registered for the url scheme, returns it
+// and calls Initialize on it.
 func New(url string, txnType TxnType) (Driver, error) {
+	return newDriver(context.Background(), url, txnType)
+}
+
+// NewContext is like New, but returns a ContextDriver and initializes
+// it with ctx.
+func NewContext(ctx context.Context, url string, txnType TxnType) (ContextDriver, error) {
+	d, err := newDriver(ctx, url, txnType)
+	if err != nil {
+		return nil, err
+	}
+	return WithContext(d), nil
+}
+
+func newDriver(ctx context.Context, url string, txnType TxnType) (Driver, error) {
 	u, err := neturl.Parse(url)
 	if err != nil {
 		return nil, err
 	}
 
-	switch u.Scheme {
-	case "postgres":
-		// For postgres we support multiple transaction strategies
-		var d Driver
-		switch txnType {
-		case TxnNone:
-			d = &postgres.NoTxnDriver{}
-			log.Println("Migration scripts will be executed with no explicit transactions")
-		case TxnPerFile:
-			d = &postgres.PerFileTxnDriver{}
-			log.Println("Each migration script will be executed in its own transaction")
-		case TxnSingle:
-			d = &postgres.SingleTxnDriver{}
-			log.Println("All migration scripts will be executed in a single transaction")
-		}
-
-		verifyFilenameExtension("postgres", d)
-		if err := d.Initialize(url); err != nil {
-			return nil, err
-		}
-		return d, nil
-
-	case "mysql":
-		d := &mysql.Driver{}
-		verifyFilenameExtension("mysql", d)
-		if err := d.Initialize(url); err != nil {
-			return nil, err
-		}
-		return d, nil
-
-	case "bash":
-		d := &bash.Driver{}
-		verifyFilenameExtension("bash", d)
-		if err := d.Initialize(url); err != nil {
-			return nil, err
-		}
-		return d, nil
-
-	case "cassandra":
-		d := &cassandra.Driver{}
-		verifyFilenameExtension("cassanda", d)
-		if err := d.Initialize(url); err != nil {
-			return nil, err
-		}
-		return d, nil
-	default:
+	factory, ok := lookup(u.Scheme)
+	if !ok {
 		return nil, errors.New(fmt.Sprintf("Driver '%s' not found.", u.Scheme))
 	}
+
+	d := factory(txnType)
+	verifyFilenameExtension(u.Scheme, d)
+	if err := WithContext(d).InitializeContext(ctx, url); err != nil {
+		return nil, err
+	}
+	return d, nil
 }
 
 // verifyFilenameExtension panics if the drivers filename extension
@@ -128,6 +174,9 @@ func GetTxnType(txnType string) (TxnType, error) {
 	case "single":
 		return TxnSingle, nil
 
+	case "single-partial":
+		return TxnSinglePartial, nil
+
 	case "perfile":
 		return TxnPerFile, nil
 	}
@@ -1,9 +1,204 @@
 package driver
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promoboxx/migrate/file"
+	"github.com/promoboxx/migrate/migrate/direction"
+)
 
 func TestNew(t *testing.T) {
 	if _, err := New("unknown://url", TxnPerFile); err == nil {
 		t.Error("no error although driver unknown")
 	}
 }
+
+type testDriver struct{}
+
+func (d *testDriver) Initialize(url string) error             { return nil }
+func (d *testDriver) Close() error                            { return nil }
+func (d *testDriver) FilenameExtension() string               { return "test" }
+func (d *testDriver) Migrate(f file.File, p chan interface{}) { close(p) }
+func (d *testDriver) Version() (uint64, error)                { return 0, nil }
+
+func TestRegister(t *testing.T) {
+	Register("registrytest", func(TxnType) Driver { return &testDriver{} })
+
+	d, err := New("registrytest://url", TxnPerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.(*testDriver); !ok {
+		t.Errorf("expected *testDriver, got %T", d)
+	}
+
+	found := false
+	for _, scheme := range List() {
+		if scheme == "registrytest" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("registered scheme missing from List()")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic although scheme registered twice")
+		}
+	}()
+	Register("registrytest", func(TxnType) Driver { return &testDriver{} })
+}
+
+func TestWithContext(t *testing.T) {
+	d := WithContext(&testDriver{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := d.InitializeContext(ctx, "registrytest://url"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	if err := d.InitializeContext(ctx, "registrytest://url"); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if _, err := d.VersionContext(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	pipe := make(chan interface{})
+	go d.MigrateContext(ctx, file.File{}, pipe)
+	var errs []error
+	for item := range pipe {
+		if err, ok := item.(error); ok {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", errs)
+	}
+}
+
+func TestPollLock(t *testing.T) {
+	lockPollInterval = time.Millisecond
+
+	tries := 0
+	acquired, err := PollLock(context.Background(), time.Second, func() (bool, error) {
+		tries++
+		return tries == 3, nil
+	})
+	if err != nil || !acquired || tries != 3 {
+		t.Errorf("expected lock after 3 tries, got %v %v after %v", acquired, err, tries)
+	}
+
+	acquired, err = PollLock(context.Background(), 10*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if err != nil || acquired {
+		t.Errorf("expected timeout, got %v %v", acquired, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := PollLock(ctx, time.Second, func() (bool, error) { return false, nil }); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLockedError(t *testing.T) {
+	holder := LockInfo{Host: "web-1", PID: 42, Since: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	err := &LockedError{Holder: &holder, Timeout: time.Minute}
+	expected := "unable to acquire migration lock within 1m0s: locked by web-1/42 since 2020-01-02T03:04:05Z"
+	if err.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, err.Error())
+	}
+}
+
+func TestExtractOptions(t *testing.T) {
+	tests := []struct {
+		url      string
+		stripped string
+		options  Options
+	}{
+		{"postgres://localhost/db", "postgres://localhost/db", Options{}},
+		{"postgres://localhost/db?sslmode=disable", "postgres://localhost/db?sslmode=disable", Options{}},
+		{"postgres://localhost/db?x-migrations-table=versions", "postgres://localhost/db", Options{"x-migrations-table": "versions"}},
+		{"postgres://localhost/db?sslmode=disable&x-migrations-schema=my%20schema&connect_timeout=5", "postgres://localhost/db?sslmode=disable&connect_timeout=5", Options{"x-migrations-schema": "my schema"}},
+		{"root@tcp(127.0.0.1:3306)/db?x-migrations-database=meta&parseTime=true", "root@tcp(127.0.0.1:3306)/db?parseTime=true", Options{"x-migrations-database": "meta"}},
+	}
+	for _, test := range tests {
+		stripped, options, err := ExtractOptions(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stripped != test.stripped {
+			t.Errorf("%v: expected %q, got %q", test.url, test.stripped, stripped)
+		}
+		if len(options) != len(test.options) {
+			t.Errorf("%v: expected %v, got %v", test.url, test.options, options)
+		}
+		for k, v := range test.options {
+			if options[k] != v {
+				t.Errorf("%v: expected %v=%q, got %q", test.url, k, v, options[k])
+			}
+		}
+	}
+
+	if (Options{}).Get("x-migrations-table", "schema_migrations") != "schema_migrations" {
+		t.Error("expected default value for missing option")
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+	f := file.File{FileName: "001_a.up.sql", Version: 1, Direction: direction.Up, Content: []byte("SELECT 1;\nSELEC 2;\n")}
+	cause := errors.New("syntax error")
+
+	e := NewMigrationError(f, cause)
+	e.Code, e.Statement, e.Hint = "42601", 2, "check the spelling"
+	e.SetPosition(f.Content, 10)
+	if e.File != f.FileName || e.Version != 1 || e.Direction != direction.Up || e.Line != 2 || e.Column != 1 {
+		t.Errorf("unexpected fields %+v", e)
+	}
+	expected := "Error 42601: syntax error in line 2, column 1 (statement 2):\nHint: check the spelling\n\n"
+	if !strings.HasPrefix(e.Error(), expected) || !strings.Contains(e.Snippet, "SELEC 2;") {
+		t.Errorf("unexpected message %q", e.Error())
+	}
+
+	var target *MigrationError
+	if err := fmt.Errorf("wrapped: %w", e); !errors.As(err, &target) || target != e {
+		t.Error("expected errors.As to find the *MigrationError")
+	}
+	if !errors.Is(e, cause) {
+		t.Error("expected errors.Is to find the cause")
+	}
+
+	// errors found in the file itself get the file fields
+	syntax := &MigrationError{Message: "unterminated '", Line: 1, Column: 8}
+	if e := NewMigrationError(f, syntax); e != syntax || e.File != f.FileName || e.Error() != "unterminated ' in line 1, column 8" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestStatement(t *testing.T) {
+	content := []byte("SELECT 1;\n\n  SELECT\n  2 ;\n")
+	stmt := NewStatement(content, 9, len(content))
+	if stmt.Query != "SELECT\n  2 ;" || stmt.Offset != 13 {
+		t.Fatalf("Unexpected statement %+v", stmt)
+	}
+	if offset := StatementOffset(stmt.Query, 2); offset != 7 {
+		t.Errorf("Expected line 2 at offset 7, got %v", offset)
+	}
+	if offset := StatementOffset(stmt.Query, 5); offset != 7 {
+		t.Errorf("Expected the last line at offset 7, got %v", offset)
+	}
+
+	e := SyntaxError(content, stmt.Offset, "broken")
+	if e.Line != 3 || e.Column != 3 || !strings.Contains(e.Snippet, "3:   SELECT") {
+		t.Errorf("Unexpected error %+v", e)
+	}
+}
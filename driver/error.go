@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/promoboxx/migrate/file"
+	"github.com/promoboxx/migrate/migrate/direction"
+)
+
+// MigrationError describes why a migration file failed. Drivers send it
+// to the pipe for errors of the database or of the file itself, so that
+// callers can inspect them with errors.As. Fields that are unknown are
+// left empty.
+type MigrationError struct {
+	File      string
+	Version   uint64
+	Direction direction.Direction
+
+	// Statement is the 1-based index of the failing statement in the file.
+	Statement int
+
+	// Line and Column locate the error in the file, starting at 1.
+	Line   int
+	Column int
+
+	// Code is the SQLSTATE or the vendor error code.
+	Code    string
+	Message string
+	Detail  string
+	Hint    string
+
+	// Snippet holds the numbered lines around Line.
+	Snippet string
+
+	// Err is the error reported by the database client, if any.
+	Err error
+}
+
+// NewMigrationError returns err as a *MigrationError for f. If err
+// already is one, e.g. a syntax error found while splitting f, the fields
+// describing f are filled in.
+func NewMigrationError(f file.File, err error) *MigrationError {
+	e, ok := err.(*MigrationError)
+	if !ok {
+		e = &MigrationError{Message: err.Error(), Err: err}
+	}
+	e.File, e.Version, e.Direction = f.FileName, f.Version, f.Direction
+	return e
+}
+
+// SetPosition sets Line, Column and Snippet from the byte offset of the
+// error in content.
+func (e *MigrationError) SetPosition(content []byte, offset int) {
+	e.Line, e.Column = file.LineColumnFromOffset(content, offset)
+	e.Snippet = string(file.LinesBeforeAndAfter(content, e.Line, 5, 5, true))
+}
+
+func (e *MigrationError) Error() string {
+	var b strings.Builder
+	if e.Code != "" {
+		fmt.Fprintf(&b, "Error %s: ", e.Code)
+	}
+	b.WriteString(e.Message)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, " in line %v, column %v", e.Line, e.Column)
+	}
+	if e.Statement > 0 {
+		fmt.Fprintf(&b, " (statement %v)", e.Statement)
+	}
+	if e.Snippet != "" {
+		b.WriteString(":")
+	}
+	if e.Detail != "" {
+		b.WriteString("\nDetail: " + e.Detail)
+	}
+	if e.Hint != "" {
+		b.WriteString("\nHint: " + e.Hint)
+	}
+	if e.Snippet != "" {
+		b.WriteString("\n\n" + e.Snippet)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of the database client.
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
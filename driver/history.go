@@ -0,0 +1,12 @@
+package driver
+
+import "context"
+
+// HistoryReader is implemented by drivers that record an
+// AppliedMigration for every applied up migration.
+type HistoryReader interface {
+	// History returns the applied migrations, ordered by version.
+	// Versions recorded by older versions of migrate carry no metadata
+	// besides the version.
+	History(ctx context.Context) ([]AppliedMigration, error)
+}
@@ -0,0 +1,101 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Locker is implemented by drivers that can hold an exclusive lock
+// across processes, so that concurrent runs against the same database
+// do not race on the version table.
+//
+// Close must release a held lock, after any pending transaction has
+// been committed or rolled back.
+type Locker interface {
+	// Lock acquires the lock, waiting at most timeout for another holder
+	// to release it. If the lock cannot be acquired in time, Lock
+	// returns a *LockedError describing the current holder.
+	Lock(ctx context.Context, info LockInfo, timeout time.Duration) error
+
+	// Unlock releases the lock.
+	Unlock() error
+}
+
+// LockInfo describes the holder of a lock.
+type LockInfo struct {
+	Host  string
+	PID   int
+	Since time.Time
+}
+
+// NewLockInfo describes the current process.
+func NewLockInfo() LockInfo {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return LockInfo{
+		Host:  host,
+		PID:   os.Getpid(),
+		Since: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func (i LockInfo) String() string {
+	return fmt.Sprintf("%s/%d since %s", i.Host, i.PID, i.Since.Format(time.RFC3339))
+}
+
+// LockedError is returned by Lock if the lock is held by someone else.
+type LockedError struct {
+	// Holder is nil if the driver could not tell who holds the lock.
+	Holder  *LockInfo
+	Timeout time.Duration
+}
+
+func (e *LockedError) Error() string {
+	holder := "another process"
+	if e.Holder != nil {
+		holder = e.Holder.String()
+	}
+	return fmt.Sprintf("unable to acquire migration lock within %v: locked by %s", e.Timeout, holder)
+}
+
+// lockPollInterval is the pause between two attempts of PollLock.
+var lockPollInterval = 500 * time.Millisecond
+
+// PollLock calls try until it reports that the lock has been acquired,
+// timeout expires or ctx is done. It is a helper for drivers whose
+// backend can only try a lock without waiting.
+func PollLock(ctx context.Context, timeout time.Duration, try func() (bool, error)) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := try()
+		if err != nil || acquired {
+			return acquired, err
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+
+		wait := lockPollInterval
+		if remaining := time.Until(deadline); remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Unwrap returns the driver adapted by WithContext, or d itself.
+// Use it to check adapted drivers for optional interfaces such as Locker.
+func Unwrap(d Driver) Driver {
+	if a, ok := d.(*contextAdapter); ok {
+		return a.Driver
+	}
+	return d
+}
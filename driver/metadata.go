@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/promoboxx/migrate/file"
+)
+
+// ToolVersion is recorded with every applied migration.
+// The CLI sets it to its own version.
+var ToolVersion = "unknown"
+
+// AppliedMigration describes how and by whom a migration was applied.
+// Drivers store it next to the version in their version table.
+type AppliedMigration struct {
+	Version     uint64
+	FileName    string
+	Name        string
+	AppliedAt   time.Time
+	Duration    time.Duration
+	Checksum    string
+	Host        string
+	User        string
+	ToolVersion string
+}
+
+// NewAppliedMigration describes f, applied by the current process.
+// started is the time the driver began to run f, the duration is
+// measured up to now. The content of f must have been read.
+func NewAppliedMigration(f file.File, started time.Time) AppliedMigration {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return AppliedMigration{
+		Version:     f.Version,
+		FileName:    f.FileName,
+		Name:        f.Name,
+		AppliedAt:   started.UTC().Truncate(time.Millisecond),
+		Duration:    time.Since(started),
+		Checksum:    f.Checksum(),
+		Host:        host,
+		User:        currentUser(),
+		ToolVersion: ToolVersion,
+	}
+}
+
+// DurationMs returns the duration in whole milliseconds.
+func (m AppliedMigration) DurationMs() int64 {
+	return int64(m.Duration / time.Millisecond)
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
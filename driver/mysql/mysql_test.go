@@ -1,10 +1,14 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-sql-driver/mysql"
+	"github.com/promoboxx/migrate/driver"
 	"github.com/promoboxx/migrate/file"
 	"github.com/promoboxx/migrate/migrate/direction"
 	pipep "github.com/promoboxx/migrate/pipe"
@@ -21,7 +25,7 @@ func TestMigrate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, err := connection.Exec(`DROP TABLE IF EXISTS yolo, yolo1, ` + tableName); err != nil {
+	if _, err := connection.Exec(`DROP TABLE IF EXISTS yolo, yolo1, ` + defaultTableName); err != nil {
 		t.Fatal(err)
 	}
 
@@ -98,3 +102,118 @@ func TestMigrate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLock(t *testing.T) {
+	driverUrl := "mysql://root@tcp(127.0.0.1:3306)/migratetest"
+
+	d1 := &Driver{}
+	if err := d1.Initialize(driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	d2 := &Driver{}
+	if err := d2.Initialize(driverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	info := driver.NewLockInfo()
+	if err := d1.Lock(context.Background(), info, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d2.Lock(context.Background(), driver.NewLockInfo(), time.Second)
+	lockedErr, ok := err.(*driver.LockedError)
+	if !ok {
+		t.Fatalf("expected *driver.LockedError, got %v", err)
+	}
+	if lockedErr.Holder == nil || lockedErr.Holder.PID != info.PID {
+		t.Errorf("expected holder %v, got %v", info, lockedErr.Holder)
+	}
+
+	// closing releases the lock
+	if err := d1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock(context.Background(), driver.NewLockInfo(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxnTypes(t *testing.T) {
+	driverUrl := "mysql://root@tcp(127.0.0.1:3306)/migratetest"
+
+	for _, d := range []driver.Driver{&Driver{}, &NoTxnDriver{}, &SingleTxnDriver{}} {
+		connection, err := sql.Open("mysql", strings.SplitN(driverUrl, "mysql://", 2)[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := connection.Exec(`DROP TABLE IF EXISTS yolo, ` + defaultTableName); err != nil {
+			t.Fatal(err)
+		}
+		connection.Close()
+
+		if err := d.Initialize(driverUrl); err != nil {
+			t.Fatal(err)
+		}
+
+		files := []file.File{
+			{Path: "/foobar", FileName: "001_foobar.up.sql", Version: 1, Name: "foobar", Direction: direction.Up,
+				Content: []byte("CREATE TABLE yolo (id int not null primary key); INSERT INTO yolo VALUES (1);")},
+			// always run files are not recorded, so running them twice is fine
+			{Path: "/foobar", FileName: "002_foobar.alwaysup.sql", Version: 2, Name: "foobar", Direction: direction.Up, Always: true,
+				Content: []byte("INSERT INTO yolo VALUES (2) ON DUPLICATE KEY UPDATE id = id;")},
+			{Path: "/foobar", FileName: "002_foobar.alwaysup.sql", Version: 2, Name: "foobar", Direction: direction.Up, Always: true,
+				Content: []byte("INSERT INTO yolo VALUES (2) ON DUPLICATE KEY UPDATE id = id;")},
+		}
+		for _, f := range files {
+			pipe := pipep.New()
+			go d.Migrate(f, pipe)
+			if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+				t.Fatalf("%T: %v", d, errs)
+			}
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		d2 := &Driver{}
+		if err := d2.Initialize(driverUrl); err != nil {
+			t.Fatal(err)
+		}
+		if version, err := d2.Version(); err != nil || version != 1 {
+			t.Errorf("%T: expected version 1, got %v (%v)", d, version, err)
+		}
+		d2.Close()
+	}
+}
+
+func TestMigrationError(t *testing.T) {
+	content := "CREATE TABLE a (id int);\n\nCREATE TABLE b (\n  id THIS WILL FAIL\n);\nINSERT INTO c VALUES (1);\n"
+	f := file.File{FileName: "001_a.up.sql", Content: []byte(content)}
+	stmts, err := splitStatements(f.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index  int
+		err    *mysql.MySQLError
+		prefix string
+	}{
+		{1, &mysql.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near 'THIS WILL FAIL\n)' at line 2"},
+			"Error 1064: You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use near 'THIS WILL FAIL\n)' in line 4, column 6 (statement 2):"},
+		{2, &mysql.MySQLError{Number: 1146, Message: "Table 'migratetest.c' doesn't exist"},
+			"Error 1146: Table 'migratetest.c' doesn't exist in line 6, column 1 (statement 3):"},
+	}
+	for _, test := range tests {
+		err := migrationError(f, test.index, stmts[test.index], test.err)
+		if !strings.HasPrefix(err.Error(), test.prefix) {
+			t.Errorf("expected prefix %q, got %q", test.prefix, err.Error())
+		}
+		if !strings.Contains(err.Error(), "CREATE TABLE b (") {
+			t.Errorf("expected the snippet in %q", err.Error())
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package mysql
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	migratedriver "github.com/promoboxx/migrate/driver"
+)
+
+const defaultDelimiter = ";"
+
+// splitStatements splits the content of a migration file into
+// statements, like the mysql client does. Delimiters inside quoted
+// strings and identifiers and inside comments are ignored. A DELIMITER
+// line changes the delimiter for the statements that follow, e.g. to
+// define stored procedures or triggers, and is not sent to the server.
+// Statements that consist of comments only are dropped.
+func splitStatements(content []byte) ([]migratedriver.Statement, error) {
+	statements := make([]migratedriver.Statement, 0)
+	delimiter := defaultDelimiter
+	start := 0
+	hasCode := false
+	lineStart := true
+
+	flush := func(end int) {
+		if hasCode {
+			statements = append(statements, migratedriver.NewStatement(content, start, end))
+		}
+		hasCode = false
+	}
+
+	for i := 0; i < len(content); {
+		c := content[i]
+
+		if lineStart && isDelimiterDirective(content[i:]) {
+			flush(i)
+			end := migratedriver.LineEnd(content, i)
+			fields := bytes.Fields(content[i:end])
+			if len(fields) < 2 {
+				return nil, migratedriver.SyntaxError(content, i, "DELIMITER without a delimiter")
+			}
+			delimiter = string(fields[1])
+			i = end
+			start = i
+			continue
+		}
+
+		switch {
+		case c == '\n':
+			lineStart = true
+			i++
+			continue
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+
+		case bytes.HasPrefix(content[i:], []byte(delimiter)):
+			flush(i)
+			i += len(delimiter)
+			start = i
+
+		case c == '\'' || c == '"' || c == '`':
+			end, ok := quoteEnd(content, i)
+			if !ok {
+				return nil, migratedriver.SyntaxError(content, i, fmt.Sprintf("unterminated %c", c))
+			}
+			hasCode = true
+			i = end
+
+		case c == '#' || isDashComment(content[i:]):
+			i = migratedriver.LineEnd(content, i)
+
+		case bytes.HasPrefix(content[i:], []byte("/*")):
+			end := bytes.Index(content[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, migratedriver.SyntaxError(content, i, "unterminated comment")
+			}
+			// /*! ... */ is executed by MySQL
+			if bytes.HasPrefix(content[i:], []byte("/*!")) {
+				hasCode = true
+			}
+			i += 2 + end + 2
+
+		default:
+			hasCode = true
+			i++
+		}
+		lineStart = false
+	}
+	flush(len(content))
+	return statements, nil
+}
+
+// isDelimiterDirective reports whether b starts with the DELIMITER
+// client command.
+func isDelimiterDirective(b []byte) bool {
+	const directive = "DELIMITER"
+	if len(b) <= len(directive) || !bytes.EqualFold(b[:len(directive)], []byte(directive)) {
+		return false
+	}
+	return b[len(directive)] == ' ' || b[len(directive)] == '\t'
+}
+
+// isDashComment reports whether b starts with a -- comment, which MySQL
+// only recognizes if followed by whitespace.
+func isDashComment(b []byte) bool {
+	if !bytes.HasPrefix(b, []byte("--")) {
+		return false
+	}
+	return len(b) == 2 || b[2] == ' ' || b[2] == '\t' || b[2] == '\r' || b[2] == '\n'
+}
+
+// quoteEnd returns the index after the quoted string or identifier that
+// starts at i. Backslash escapes apply to strings, not to identifiers.
+// A doubled quote is read as two adjacent quoted parts.
+func quoteEnd(content []byte, i int) (int, bool) {
+	quote := content[i]
+	for j := i + 1; j < len(content); j++ {
+		switch content[j] {
+		case '\\':
+			if quote != '`' {
+				j++
+			}
+		case quote:
+			return j + 1, true
+		}
+	}
+	return 0, false
+}
+
+// implicitCommitKeywords start statements that MySQL commits implicitly,
+// see https://dev.mysql.com/doc/refman/8.0/en/implicit-commit.html
+var implicitCommitKeywords = map[string]bool{
+	"ALTER":     true,
+	"ANALYZE":   true,
+	"CREATE":    true,
+	"DROP":      true,
+	"GRANT":     true,
+	"INSTALL":   true,
+	"LOCK":      true,
+	"OPTIMIZE":  true,
+	"RENAME":    true,
+	"REPAIR":    true,
+	"REVOKE":    true,
+	"TRUNCATE":  true,
+	"UNINSTALL": true,
+}
+
+// implicitCommit reports whether query commits the running transaction
+// and returns its leading keywords, e.g. "CREATE TABLE".
+func implicitCommit(query string) (string, bool) {
+	words := strings.Fields(strings.ToUpper(stripLeadingComments(query)))
+	if len(words) == 0 || !implicitCommitKeywords[words[0]] {
+		return "", false
+	}
+	if len(words) == 1 {
+		return words[0], true
+	}
+	// temporary tables do not commit
+	if words[1] == "TEMPORARY" {
+		return "", false
+	}
+	return words[0] + " " + words[1], true
+}
+
+// commitsImplicitly reports whether any of stmts commits the running
+// transaction.
+func commitsImplicitly(stmts []migratedriver.Statement) bool {
+	for _, stmt := range stmts {
+		if _, ok := implicitCommit(stmt.Query); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func stripLeadingComments(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n")
+		switch {
+		case strings.HasPrefix(query, "#") || isDashComment([]byte(query)):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end:]
+		case strings.HasPrefix(query, "/*") && !strings.HasPrefix(query, "/*!"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		default:
+			return query
+		}
+	}
+}
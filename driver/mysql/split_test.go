@@ -0,0 +1,100 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"CREATE TABLE a (id int); CREATE TABLE b (id int);", []string{"CREATE TABLE a (id int)", "CREATE TABLE b (id int)"}},
+		{"INSERT INTO a VALUES ('x;y', \"z;\", 'it''s;', 'a\\';b');", []string{"INSERT INTO a VALUES ('x;y', \"z;\", 'it''s;', 'a\\';b')"}},
+		{"CREATE TABLE `a;b` (id int);", []string{"CREATE TABLE `a;b` (id int)"}},
+		{"-- comment; here\nSELECT 1; # another; one\nSELECT 2 /* in; line */;", []string{"-- comment; here\nSELECT 1", "# another; one\nSELECT 2 /* in; line */"}},
+		{"SELECT 1 --1;\n;", []string{"SELECT 1 --1"}},
+		{"/* only a comment; */\n-- and another\n", []string{}},
+		{"/*!40101 SET NAMES utf8 */;", []string{"/*!40101 SET NAMES utf8 */"}},
+		{
+			"DELIMITER //\nCREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND//\ndelimiter ;\nCALL p();",
+			[]string{"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND", "CALL p()"},
+		},
+		{"SELECT 1", []string{"SELECT 1"}},
+	}
+
+	for _, test := range tests {
+		statements, err := splitStatements([]byte(test.content))
+		if err != nil {
+			t.Errorf("%q: %v", test.content, err)
+			continue
+		}
+		queries := make([]string, 0)
+		for _, s := range statements {
+			queries = append(queries, s.Query)
+			if !strings.HasPrefix(test.content[s.Offset:], s.Query) {
+				t.Errorf("%q: wrong offset %v for %q", test.content, s.Offset, s.Query)
+			}
+		}
+		if strings.Join(queries, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("%q: expected %q, got %q", test.content, test.expected, queries)
+		}
+	}
+}
+
+func TestSplitStatementsErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		message string
+	}{
+		{"SELECT 1;\nSELECT 'abc;", "unterminated ' in line 2, column 8"},
+		{"SELECT 1 /* never closed", "unterminated comment in line 1, column 10"},
+		{"DELIMITER \nSELECT 1", "DELIMITER without a delimiter in line 1, column 1"},
+	}
+	for _, test := range tests {
+		_, err := splitStatements([]byte(test.content))
+		// the message is followed by the snippet around the position
+		if err == nil || !strings.HasPrefix(err.Error(), test.message+":\n") {
+			t.Errorf("%q: expected error %q, got %v", test.content, test.message, err)
+		}
+	}
+}
+
+func TestImplicitCommit(t *testing.T) {
+	tests := []struct {
+		query    string
+		keywords string
+		commits  bool
+	}{
+		{"CREATE TABLE a (id int)", "CREATE TABLE", true},
+		{"-- add a column\n/* really */ alter table a add column b int", "ALTER TABLE", true},
+		{"CREATE TEMPORARY TABLE t (id int)", "", false},
+		{"INSERT INTO a VALUES (1)", "", false},
+		{"/*!40101 SET NAMES utf8 */", "", false},
+		{"TRUNCATE a", "TRUNCATE A", true},
+	}
+	for _, test := range tests {
+		keywords, commits := implicitCommit(test.query)
+		if keywords != test.keywords || commits != test.commits {
+			t.Errorf("%q: expected %q %v, got %q %v", test.query, test.keywords, test.commits, keywords, commits)
+		}
+	}
+}
+
+func TestCommitsImplicitly(t *testing.T) {
+	stmts, err := splitStatements([]byte("INSERT INTO a VALUES (1);\nCREATE TEMPORARY TABLE b (id int);"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commitsImplicitly(stmts) {
+		t.Error("Expected no implicit commit")
+	}
+	stmts, err = splitStatements([]byte("INSERT INTO a VALUES (1);\nALTER TABLE a ADD COLUMN b int;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !commitsImplicitly(stmts) {
+		t.Error("Expected an implicit commit")
+	}
+}
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	neturl "net/url"
+	"strings"
+)
+
+// optionPrefix marks url query parameters that are meant for migrate
+// itself rather than for the database driver.
+const optionPrefix = "x-"
+
+// Options holds the migrate specific url query parameters.
+type Options map[string]string
+
+// Get returns the option value, or def if the option is not set.
+func (o Options) Get(key, def string) string {
+	if v, ok := o[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// ExtractOptions removes all query parameters starting with "x-" from
+// url and returns them. The rest of url is left untouched, so that it
+// can be handed to lib/pq, go-sql-driver/mysql or gocql as is.
+func ExtractOptions(url string) (string, Options, error) {
+	options := Options{}
+	i := strings.Index(url, "?")
+	if i < 0 {
+		return url, options, nil
+	}
+
+	base, query := url[:i], url[i+1:]
+	kept := make([]string, 0)
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		key, value := param, ""
+		if j := strings.Index(param, "="); j >= 0 {
+			key, value = param[:j], param[j+1:]
+		}
+		key, err := neturl.QueryUnescape(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if !strings.HasPrefix(key, optionPrefix) {
+			kept = append(kept, param)
+			continue
+		}
+		value, err = neturl.QueryUnescape(value)
+		if err != nil {
+			return "", nil, err
+		}
+		options[key] = value
+	}
+
+	if len(kept) == 0 {
+		return base, options, nil
+	}
+	return base + "?" + strings.Join(kept, "&"), options, nil
+}
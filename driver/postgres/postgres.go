@@ -2,18 +2,28 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
-	"errors"
 	"fmt"
+	"hash/crc32"
+	"log"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
+	migratedriver "github.com/promoboxx/migrate/driver"
 	"github.com/promoboxx/migrate/file"
 	"github.com/promoboxx/migrate/migrate/direction"
 )
 
 type PerFileTxnDriver struct {
-	db *sql.DB
+	db   *sql.DB
+	lock *advisoryLock
+
+	// quoted, optionally schema qualified names of the bookkeeping tables
+	tableName     string
+	lockTableName string
 }
 
 type NoTxnDriver struct {
@@ -24,37 +34,139 @@ type SingleTxnDriver struct {
 	PerFileTxnDriver
 	rollback bool
 	txn      *sql.Tx
+
+	// partial commits the files before a failing one
+	partial bool
+
+	// applied are the files that succeeded so far
+	applied []file.File
+}
+
+const defaultTableName = "schema_migrations"
+
+// savepointName marks the start of the running file in the transaction
+// of the SingleTxnDriver.
+const savepointName = "migrate_file"
+
+// metadataColumns describe an applied migration next to its version.
+// Only dirty has a default, the others stay NULL for versions recorded
+// before they were added.
+var metadataColumns = []string{
+	"dirty boolean not null default false",
+	"file_name text",
+	"name text",
+	"applied_at timestamptz",
+	"duration_ms bigint",
+	"checksum text",
+	"host text",
+	"os_user text",
+	"tool_version text",
+}
+
+// execer is satisfied by *sql.DB, *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
-const tableName = "schema_migrations"
+func init() {
+	// For postgres we support multiple transaction strategies
+	migratedriver.Register("postgres", func(txnType migratedriver.TxnType) migratedriver.Driver {
+		switch txnType {
+		case migratedriver.TxnNone:
+			log.Println("Migration scripts will be executed with no explicit transactions")
+			return &NoTxnDriver{}
+		case migratedriver.TxnSingle:
+			log.Println("All migration scripts will be executed in a single transaction")
+			return &SingleTxnDriver{}
+		case migratedriver.TxnSinglePartial:
+			log.Println("All migration scripts will be executed in a single transaction, which keeps the scripts before a failing one")
+			return &SingleTxnDriver{partial: true}
+		default:
+			log.Println("Each migration script will be executed in its own transaction")
+			return &PerFileTxnDriver{}
+		}
+	})
+}
 
 func (driver *PerFileTxnDriver) Initialize(url string) error {
+	return driver.InitializeContext(context.Background(), url)
+}
+
+// Postgres Driver URL format:
+// postgres://user@host:port/database?x-migrations-table=name&x-migrations-schema=name
+//
+// The x-migrations-* options are removed before the url is handed to lib/pq.
+// x-migrations-schema is created if it does not exist.
+func (driver *PerFileTxnDriver) InitializeContext(ctx context.Context, url string) error {
+	url, schema, table, err := parseURL(url)
+	if err != nil {
+		return err
+	}
+
 	db, err := sql.Open("postgres", url)
 	if err != nil {
 		return err
 	}
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return err
 	}
 	driver.db = db
 
-	if err := driver.ensureVersionTableExists(); err != nil {
+	driver.tableName = qualifiedName(schema, table)
+	driver.lockTableName = qualifiedName(schema, table+"_lock")
+	if schema != "" {
+		if _, err := db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(schema)); err != nil {
+			return err
+		}
+	}
+
+	if err := driver.ensureVersionTableExists(ctx, schema, table); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (driver *PerFileTxnDriver) Close() error {
+	if err := driver.Unlock(); err != nil {
+		driver.db.Close()
+		return err
+	}
 	if err := driver.db.Close(); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (driver *PerFileTxnDriver) ensureVersionTableExists() error {
-	if _, err := driver.db.Exec("CREATE TABLE IF NOT EXISTS " + tableName + " (version int not null primary key);"); err != nil {
+// ensureVersionTableExists creates the version table and adds the columns
+// that tables of older versions lack. ALTER TABLE takes an ACCESS
+// EXCLUSIVE lock, so it only runs for columns that are actually missing.
+func (driver *PerFileTxnDriver) ensureVersionTableExists(ctx context.Context, schema, table string) error {
+	if _, err := driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.tableName+" (version int not null primary key, "+strings.Join(metadataColumns, ", ")+");"); err != nil {
+		return err
+	}
+	rows, err := driver.db.QueryContext(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = COALESCE(NULLIF($1, ''), current_schema()) AND table_name = $2", schema, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
 		return err
 	}
+	for _, column := range metadataColumns {
+		if !existing[strings.Fields(column)[0]] {
+			if _, err := driver.db.ExecContext(ctx, "ALTER TABLE "+driver.tableName+" ADD COLUMN IF NOT EXISTS "+column+";"); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -63,10 +175,30 @@ func (driver *PerFileTxnDriver) FilenameExtension() string {
 }
 
 func (driver *PerFileTxnDriver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext runs f and its bookkeeping in a transaction, unless f
+// has the no-transaction directive.
+func (driver *PerFileTxnDriver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
 
-	tx, err := driver.db.Begin()
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+	directives, err := migratedriver.ReadDirectives(driver, f)
+	if err != nil {
+		pipe <- err
+		return
+	}
+	if directives.NoTransaction {
+		migrateWithoutTxn(ctx, driver.db, driver.tableName, f, directives, pipe)
+		return
+	}
+
+	tx, err := driver.db.BeginTx(ctx, nil)
 	if err != nil {
 		pipe <- err
 		return
@@ -75,7 +207,7 @@ func (driver *PerFileTxnDriver) Migrate(f file.File, pipe chan interface{}) {
 	// Don't update the version number to count always run files
 	if f.Always == false {
 		if f.Direction == direction.Up {
-			if _, err := tx.Exec("INSERT INTO "+tableName+" (version) VALUES ($1)", f.Version); err != nil {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO "+driver.tableName+" (version) VALUES ($1)", f.Version); err != nil {
 				pipe <- err
 				if err := tx.Rollback(); err != nil {
 					pipe <- err
@@ -83,7 +215,7 @@ func (driver *PerFileTxnDriver) Migrate(f file.File, pipe chan interface{}) {
 				return
 			}
 		} else if f.Direction == direction.Down {
-			if _, err := tx.Exec("DELETE FROM "+tableName+" WHERE version=$1", f.Version); err != nil {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.tableName+" WHERE version=$1", f.Version); err != nil {
 				pipe <- err
 				if err := tx.Rollback(); err != nil {
 					pipe <- err
@@ -93,7 +225,8 @@ func (driver *PerFileTxnDriver) Migrate(f file.File, pipe chan interface{}) {
 		}
 	}
 
-	if err := f.ReadContent(); err != nil {
+	// SET LOCAL ends with the transaction
+	if err := execAll(ctx, tx, settingStatements(directives, true)); err != nil {
 		pipe <- err
 		if err := tx.Rollback(); err != nil {
 			pipe <- err
@@ -101,32 +234,43 @@ func (driver *PerFileTxnDriver) Migrate(f file.File, pipe chan interface{}) {
 		return
 	}
 
-	if _, err := tx.Exec(string(f.Content)); err != nil {
-		pqErr := err.(*pq.Error)
-		offset, err := strconv.Atoi(pqErr.Position)
-		if err == nil && offset >= 0 {
-			lineNo, columnNo := file.LineColumnFromOffset(f.Content, offset-1)
-			errorPart := file.LinesBeforeAndAfter(f.Content, lineNo, 5, 5, true)
-			pipe <- errors.New(fmt.Sprintf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart)))
-		} else {
-			pipe <- errors.New(fmt.Sprintf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message))
-		}
-
+	started := time.Now()
+	if _, err := tx.ExecContext(ctx, string(f.Content)); err != nil {
+		pipe <- migrationError(f, err)
 		if err := tx.Rollback(); err != nil {
 			pipe <- err
 		}
 		return
 	}
 
+	if f.Always == false && f.Direction == direction.Up {
+		if err := recordApplied(ctx, tx, driver.tableName, f, started); err != nil {
+			pipe <- err
+			if err := tx.Rollback(); err != nil {
+				pipe <- err
+			}
+			return
+		}
+	}
+
 	if err := tx.Commit(); err != nil {
 		pipe <- err
 		return
 	}
 }
 
+// ValidateDirectives supports all directives.
+func (driver *PerFileTxnDriver) ValidateDirectives(d file.Directives) error {
+	return d.CheckSupported(file.NoTransaction, file.StatementTimeout, file.LockTimeout, file.Set)
+}
+
 func (driver *PerFileTxnDriver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *PerFileTxnDriver) VersionContext(ctx context.Context) (uint64, error) {
 	var version uint64
-	err := driver.db.QueryRow("SELECT version FROM " + tableName + " ORDER BY version DESC LIMIT 1").Scan(&version)
+	err := driver.db.QueryRowContext(ctx, "SELECT version FROM "+driver.tableName+" ORDER BY version DESC LIMIT 1").Scan(&version)
 	switch {
 	case err == sql.ErrNoRows:
 		return 0, nil
@@ -137,47 +281,92 @@ func (driver *PerFileTxnDriver) Version() (uint64, error) {
 	}
 }
 
-func (driver *NoTxnDriver) Migrate(f file.File, pipe chan interface{}) {
-	defer close(pipe)
-	pipe <- f
+func (driver *PerFileTxnDriver) History(ctx context.Context) ([]migratedriver.AppliedMigration, error) {
+	return history(ctx, driver.db, driver.tableName)
+}
 
-	// Don't update the version number to count always run files
-	if f.Always == false {
-		if f.Direction == direction.Up {
-			if _, err := driver.db.Exec("INSERT INTO "+tableName+" (version) VALUES ($1)", f.Version); err != nil {
-				pipe <- err
-				return
-			}
-		} else if f.Direction == direction.Down {
-			if _, err := driver.db.Exec("DELETE FROM "+tableName+" WHERE version=$1", f.Version); err != nil {
-				pipe <- err
-				return
-			}
+func (driver *PerFileTxnDriver) Dirty(ctx context.Context) (uint64, bool, error) {
+	var version uint64
+	err := driver.db.QueryRowContext(ctx, "SELECT version FROM "+driver.tableName+" WHERE dirty ORDER BY version DESC LIMIT 1").Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return version, true, nil
+	}
+}
+
+func (driver *PerFileTxnDriver) Force(ctx context.Context, version uint64) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+driver.tableName+" WHERE version > $1", version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE "+driver.tableName+" SET dirty = false"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if version > 0 {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+driver.tableName+" (version) VALUES ($1) ON CONFLICT (version) DO NOTHING", version); err != nil {
+			tx.Rollback()
+			return err
 		}
 	}
+	return tx.Commit()
+}
+
+// Lock takes a session level advisory lock on a dedicated connection.
+// The holder is recorded in the lock table so that others can report it.
+func (driver *PerFileTxnDriver) Lock(ctx context.Context, info migratedriver.LockInfo, timeout time.Duration) error {
+	lock, err := newAdvisoryLock(ctx, driver.db, driver.tableName, driver.lockTableName, info, timeout)
+	if err != nil {
+		return err
+	}
+	driver.lock = lock
+	return nil
+}
+
+func (driver *PerFileTxnDriver) Unlock() error {
+	if driver.lock == nil {
+		return nil
+	}
+	err := driver.lock.release()
+	driver.lock = nil
+	return err
+}
+
+func (driver *NoTxnDriver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext runs f without a transaction, see migrateWithoutTxn.
+func (driver *NoTxnDriver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	pipe <- f
 
 	if err := f.ReadContent(); err != nil {
 		pipe <- err
 		return
 	}
-
-	if _, err := driver.db.Exec(string(f.Content)); err != nil {
-		pqErr := err.(*pq.Error)
-		offset, err := strconv.Atoi(pqErr.Position)
-		if err == nil && offset >= 0 {
-			lineNo, columnNo := file.LineColumnFromOffset(f.Content, offset-1)
-			errorPart := file.LinesBeforeAndAfter(f.Content, lineNo, 5, 5, true)
-			pipe <- errors.New(fmt.Sprintf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart)))
-		} else {
-			pipe <- errors.New(fmt.Sprintf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message))
-		}
-
+	directives, err := migratedriver.ReadDirectives(driver, f)
+	if err != nil {
+		pipe <- err
 		return
 	}
+	migrateWithoutTxn(ctx, driver.db, driver.tableName, f, directives, pipe)
 }
 
 func (driver *SingleTxnDriver) Initialize(url string) error {
-	err := driver.PerFileTxnDriver.Initialize(url)
+	return driver.InitializeContext(context.Background(), url)
+}
+
+func (driver *SingleTxnDriver) InitializeContext(ctx context.Context, url string) error {
+	err := driver.PerFileTxnDriver.InitializeContext(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -202,45 +391,368 @@ func (driver *SingleTxnDriver) Close() error {
 	return driver.PerFileTxnDriver.Close()
 }
 
+// RollbackOnClose makes Close roll back the transaction, even if all
+// files succeeded.
+func (driver *SingleTxnDriver) RollbackOnClose() {
+	driver.rollback = true
+}
+
 func (driver *SingleTxnDriver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext runs f in a savepoint of the transaction. If f fails,
+// only the savepoint is rolled back and the files that ran before f are
+// reported: they are rolled back on Close, or committed if partial.
+func (driver *SingleTxnDriver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
 	defer close(pipe)
 	pipe <- f
 
+	if _, err := driver.txn.ExecContext(ctx, "SAVEPOINT "+savepointName); err != nil {
+		pipe <- err
+		driver.rollback = true
+		pipe <- failureReport(f, driver.applied, false)
+		return
+	}
+	if err := driver.migrate(ctx, f); err != nil {
+		pipe <- err
+		driver.rollbackFile(f, pipe)
+		return
+	}
+	if _, err := driver.txn.ExecContext(ctx, "RELEASE SAVEPOINT "+savepointName); err != nil {
+		pipe <- err
+		driver.rollbackFile(f, pipe)
+		return
+	}
+	driver.applied = append(driver.applied, f)
+}
+
+func (driver *SingleTxnDriver) migrate(ctx context.Context, f file.File) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+	directives, err := migratedriver.ReadDirectives(driver, f)
+	if err != nil {
+		return err
+	}
+
+	// Don't update the version number to count always run files
+	if f.Always == false {
+		if f.Direction == direction.Up {
+			if _, err := driver.txn.ExecContext(ctx, "INSERT INTO "+driver.tableName+" (version) VALUES ($1)", f.Version); err != nil {
+				return err
+			}
+		} else if f.Direction == direction.Down {
+			if _, err := driver.txn.ExecContext(ctx, "DELETE FROM "+driver.tableName+" WHERE version=$1", f.Version); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := execAll(ctx, driver.txn, settingStatements(directives, true)); err != nil {
+		return err
+	}
+
+	started := time.Now()
+	if _, err := driver.txn.ExecContext(ctx, string(f.Content)); err != nil {
+		return migrationError(f, err)
+	}
+
+	// the settings of f must not apply to the files after it
+	if err := execAll(ctx, driver.txn, resetStatements(directives)); err != nil {
+		return err
+	}
+
+	if f.Always == false && f.Direction == direction.Up {
+		if err := recordApplied(ctx, driver.txn, driver.tableName, f, started); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackFile rolls back the savepoint of the failed file f and reports
+// what happens to the files before it.
+func (driver *SingleTxnDriver) rollbackFile(f file.File, pipe chan interface{}) {
+	// ctx may be done, which must not keep the savepoint from rolling back
+	if _, err := driver.txn.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+savepointName); err != nil {
+		pipe <- err
+		driver.rollback = true
+	}
+	if !driver.partial {
+		driver.rollback = true
+	}
+	pipe <- failureReport(f, driver.applied, !driver.rollback)
+}
+
+// ValidateDirectives rejects no-transaction, as all files share one
+// transaction.
+func (driver *SingleTxnDriver) ValidateDirectives(d file.Directives) error {
+	return d.CheckSupported(file.StatementTimeout, file.LockTimeout, file.Set)
+}
+
+// migrateWithoutTxn flags the version as dirty before the file runs and
+// only clears the flag once it succeeded, because without a transaction
+// a failed file may leave the database half migrated. The file runs on a
+// dedicated connection, whose settings are reset afterwards.
+func migrateWithoutTxn(ctx context.Context, db *sql.DB, tableName string, f file.File, directives file.Directives, pipe chan interface{}) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		pipe <- err
+		return
+	}
+	defer conn.Close()
+
+	if err := execAll(ctx, conn, settingStatements(directives, false)); err != nil {
+		pipe <- err
+		return
+	}
+	defer func() {
+		// the connection goes back to the pool, even if ctx is done
+		if err := execAll(context.Background(), conn, resetStatements(directives)); err != nil {
+			pipe <- err
+		}
+	}()
+
 	// Don't update the version number to count always run files
 	if f.Always == false {
 		if f.Direction == direction.Up {
-			if _, err := driver.txn.Exec("INSERT INTO "+tableName+" (version) VALUES ($1)", f.Version); err != nil {
+			if _, err := conn.ExecContext(ctx, "INSERT INTO "+tableName+" (version, dirty) VALUES ($1, true)", f.Version); err != nil {
 				pipe <- err
-				driver.rollback = true
 				return
 			}
 		} else if f.Direction == direction.Down {
-			if _, err := driver.txn.Exec("DELETE FROM "+tableName+" WHERE version=$1", f.Version); err != nil {
+			if _, err := conn.ExecContext(ctx, "UPDATE "+tableName+" SET dirty = true WHERE version=$1", f.Version); err != nil {
 				pipe <- err
-				driver.rollback = true
 				return
 			}
 		}
 	}
 
-	if err := f.ReadContent(); err != nil {
-		pipe <- err
-		driver.rollback = true
+	started := time.Now()
+	if _, err := conn.ExecContext(ctx, string(f.Content)); err != nil {
+		pipe <- migrationError(f, err)
 		return
 	}
 
-	if _, err := driver.txn.Exec(string(f.Content)); err != nil {
-		pqErr := err.(*pq.Error)
-		offset, err := strconv.Atoi(pqErr.Position)
-		if err == nil && offset >= 0 {
-			lineNo, columnNo := file.LineColumnFromOffset(f.Content, offset-1)
-			errorPart := file.LinesBeforeAndAfter(f.Content, lineNo, 5, 5, true)
-			pipe <- errors.New(fmt.Sprintf("%s %v: %s in line %v, column %v:\n\n%s", pqErr.Severity, pqErr.Code, pqErr.Message, lineNo, columnNo, string(errorPart)))
-		} else {
-			pipe <- errors.New(fmt.Sprintf("%s %v: %s", pqErr.Severity, pqErr.Code, pqErr.Message))
+	if f.Always == false {
+		if f.Direction == direction.Up {
+			if err := recordApplied(ctx, conn, tableName, f, started); err != nil {
+				pipe <- err
+				return
+			}
+		} else if f.Direction == direction.Down {
+			if _, err := conn.ExecContext(ctx, "DELETE FROM "+tableName+" WHERE version=$1", f.Version); err != nil {
+				pipe <- err
+				return
+			}
 		}
+	}
+}
 
-		driver.rollback = true
-		return
+// failureReport tells that f failed and whether the files applied
+// before it in the same transaction are committed or rolled back.
+func failureReport(f file.File, applied []file.File, commit bool) string {
+	report := f.FileName + " failed and was rolled back"
+	if len(applied) == 0 {
+		return report
+	}
+
+	files := "files"
+	if len(applied) == 1 {
+		files = "file"
+	}
+	names := applied[0].FileName
+	if len(applied) > 1 {
+		names += ".." + applied[len(applied)-1].FileName
+	}
+	if commit {
+		return fmt.Sprintf("%s, committing the %d %s before it: %s", report, len(applied), files, names)
+	}
+	return fmt.Sprintf("%s, rolling back the %d %s before it: %s", report, len(applied), files, names)
+}
+
+// settingStatements returns the statements that apply the timeouts and
+// settings of directives. With local, they end with the transaction.
+func settingStatements(directives file.Directives, local bool) []string {
+	set := "SET "
+	if local {
+		set = "SET LOCAL "
+	}
+	stmts := make([]string, 0)
+	if directives.StatementTimeout > 0 {
+		stmts = append(stmts, set+"statement_timeout = "+milliseconds(directives.StatementTimeout))
+	}
+	if directives.LockTimeout > 0 {
+		stmts = append(stmts, set+"lock_timeout = "+milliseconds(directives.LockTimeout))
+	}
+	for _, setting := range directives.Settings {
+		stmts = append(stmts, set+setting.Name+" = "+setting.Value)
+	}
+	return stmts
+}
+
+// resetStatements returns the statements that undo settingStatements.
+func resetStatements(directives file.Directives) []string {
+	stmts := make([]string, 0)
+	if directives.StatementTimeout > 0 {
+		stmts = append(stmts, "RESET statement_timeout")
+	}
+	if directives.LockTimeout > 0 {
+		stmts = append(stmts, "RESET lock_timeout")
+	}
+	for _, setting := range directives.Settings {
+		stmts = append(stmts, "RESET "+setting.Name)
+	}
+	return stmts
+}
+
+// milliseconds formats d in whole milliseconds, rounded up.
+func milliseconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Millisecond-1)/time.Millisecond), 10)
+}
+
+func execAll(ctx context.Context, e execer, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := e.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordApplied stores the metadata of an applied up migration in its
+// version row and clears the dirty flag.
+func recordApplied(ctx context.Context, e execer, tableName string, f file.File, started time.Time) error {
+	m := migratedriver.NewAppliedMigration(f, started)
+	_, err := e.ExecContext(ctx, "UPDATE "+tableName+" SET dirty = false, file_name = $2, name = $3, applied_at = $4, duration_ms = $5, "+
+		"checksum = $6, host = $7, os_user = $8, tool_version = $9 WHERE version = $1",
+		m.Version, m.FileName, m.Name, m.AppliedAt, m.DurationMs(), m.Checksum, m.Host, m.User, m.ToolVersion)
+	return err
+}
+
+// history reads the clean rows of the version table.
+func history(ctx context.Context, db *sql.DB, tableName string) ([]migratedriver.AppliedMigration, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, file_name, name, applied_at, duration_ms, checksum, host, os_user, tool_version FROM "+tableName+" WHERE NOT dirty ORDER BY version ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make([]migratedriver.AppliedMigration, 0)
+	for rows.Next() {
+		var m migratedriver.AppliedMigration
+		var fileName, name, checksum, host, user, toolVersion sql.NullString
+		var appliedAt sql.NullTime
+		var durationMs sql.NullInt64
+		if err := rows.Scan(&m.Version, &fileName, &name, &appliedAt, &durationMs, &checksum, &host, &user, &toolVersion); err != nil {
+			return nil, err
+		}
+		m.FileName, m.Name, m.Checksum = fileName.String, name.String, checksum.String
+		m.Host, m.User, m.ToolVersion = host.String, user.String, toolVersion.String
+		m.AppliedAt = appliedAt.Time
+		m.Duration = time.Duration(durationMs.Int64) * time.Millisecond
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// migrationError describes err returned from executing the content of f.
+// Postgres errors carry a code, details and a position, which is resolved
+// to line and column. Anything else, e.g. a cancelled context, only
+// carries its message.
+func migrationError(f file.File, err error) error {
+	e := migratedriver.NewMigrationError(f, err)
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return e
+	}
+	e.Code, e.Message, e.Detail, e.Hint = string(pqErr.Code), pqErr.Message, pqErr.Detail, pqErr.Hint
+	if offset, err := strconv.Atoi(pqErr.Position); err == nil && offset > 0 {
+		e.SetPosition(f.Content, offset-1)
+	}
+	return e
+}
+
+// advisoryLock is a session level advisory lock held on a dedicated connection.
+type advisoryLock struct {
+	conn      *sql.Conn
+	id        int64
+	tableName string
+}
+
+// newAdvisoryLock locks the version table tableName and records the
+// holder in lockTableName.
+func newAdvisoryLock(ctx context.Context, db *sql.DB, tableName, lockTableName string, info migratedriver.LockInfo, timeout time.Duration) (*advisoryLock, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l := &advisoryLock{conn: conn, tableName: lockTableName}
+
+	var database string
+	if err := conn.QueryRowContext(ctx, "SELECT current_database()").Scan(&database); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	l.id = int64(crc32.ChecksumIEEE([]byte(database + "." + tableName)))
+
+	acquired, err := migratedriver.PollLock(ctx, timeout, func() (bool, error) {
+		var acquired bool
+		err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.id).Scan(&acquired)
+		return acquired, err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		lockedErr := &migratedriver.LockedError{Timeout: timeout}
+		var holder migratedriver.LockInfo
+		if err := conn.QueryRowContext(ctx, "SELECT host, pid, since FROM "+lockTableName+" WHERE id = 1").Scan(&holder.Host, &holder.PID, &holder.Since); err == nil {
+			lockedErr.Holder = &holder
+		}
+		conn.Close()
+		return nil, lockedErr
+	}
+
+	// Creating the table under the advisory lock keeps concurrent runs
+	// from racing on it.
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+lockTableName+" (id int not null primary key, host text not null, pid int not null, since timestamptz not null);"); err != nil {
+		l.release()
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "INSERT INTO "+lockTableName+" (id, host, pid, since) VALUES (1, $1, $2, $3) ON CONFLICT (id) DO UPDATE SET host = EXCLUDED.host, pid = EXCLUDED.pid, since = EXCLUDED.since", info.Host, info.PID, info.Since); err != nil {
+		l.release()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *advisoryLock) release() error {
+	defer l.conn.Close()
+	if _, err := l.conn.ExecContext(context.Background(), "DELETE FROM "+l.tableName+" WHERE id = 1"); err != nil {
+		return err
+	}
+	if _, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.id); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseURL removes the x-migrations-* options from url and returns them.
+func parseURL(url string) (dsn, schema, table string, err error) {
+	dsn, options, err := migratedriver.ExtractOptions(url)
+	if err != nil {
+		return "", "", "", err
+	}
+	return dsn, options.Get("x-migrations-schema", ""), options.Get("x-migrations-table", defaultTableName), nil
+}
+
+// qualifiedName quotes table and prefixes it with schema, if set.
+func qualifiedName(schema, table string) string {
+	if schema == "" {
+		return pq.QuoteIdentifier(table)
 	}
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(table)
 }
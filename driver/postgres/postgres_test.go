@@ -1,9 +1,13 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/promoboxx/migrate/driver"
 	"github.com/promoboxx/migrate/file"
 	"github.com/promoboxx/migrate/migrate/direction"
 	pipep "github.com/promoboxx/migrate/pipe"
@@ -21,7 +25,7 @@ func TestMigrate(t *testing.T) {
 	}
 	if _, err := connection.Exec(`
 				DROP TABLE IF EXISTS yolo;
-				DROP TABLE IF EXISTS ` + tableName + `;`); err != nil {
+				DROP TABLE IF EXISTS ` + defaultTableName + `;`); err != nil {
 		t.Fatal(err)
 	}
 
@@ -92,3 +96,155 @@ func TestMigrate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestLock(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	d1 := &PerFileTxnDriver{}
+	if err := d1.Initialize(driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	d2 := &PerFileTxnDriver{}
+	if err := d2.Initialize(driverUrl); err != nil {
+		t.Fatal(err)
+	}
+
+	info := driver.NewLockInfo()
+	if err := d1.Lock(context.Background(), info, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d2.Lock(context.Background(), driver.NewLockInfo(), time.Second)
+	lockedErr, ok := err.(*driver.LockedError)
+	if !ok {
+		t.Fatalf("expected *driver.LockedError, got %v", err)
+	}
+	if lockedErr.Holder == nil || lockedErr.Holder.PID != info.PID {
+		t.Errorf("expected holder %v, got %v", info, lockedErr.Holder)
+	}
+
+	// closing releases the lock
+	if err := d1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Lock(context.Background(), driver.NewLockInfo(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSettingStatements(t *testing.T) {
+	directives, err := file.ParseDirectives([]byte("-- migrate:statement_timeout=1500us\n-- migrate:lock_timeout=5s\n-- migrate:set search_path=app\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "SET LOCAL statement_timeout = 2|SET LOCAL lock_timeout = 5000|SET LOCAL search_path = app"
+	if stmts := strings.Join(settingStatements(directives, true), "|"); stmts != expected {
+		t.Errorf("expected %q, got %q", expected, stmts)
+	}
+	expected = "SET statement_timeout = 2|SET lock_timeout = 5000|SET search_path = app"
+	if stmts := strings.Join(settingStatements(directives, false), "|"); stmts != expected {
+		t.Errorf("expected %q, got %q", expected, stmts)
+	}
+	expected = "RESET statement_timeout|RESET lock_timeout|RESET search_path"
+	if stmts := strings.Join(resetStatements(directives), "|"); stmts != expected {
+		t.Errorf("expected %q, got %q", expected, stmts)
+	}
+
+	if err := (&SingleTxnDriver{}).ValidateDirectives(file.Directives{NoTransaction: true}); err == nil {
+		t.Error("expected no-transaction to be rejected in a single transaction")
+	}
+}
+
+func TestSingleTxnPartial(t *testing.T) {
+	driverUrl := "postgres://localhost/migratetest?sslmode=disable"
+
+	connection, err := sql.Open("postgres", driverUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if _, err := connection.Exec(`
+				DROP TABLE IF EXISTS partial_a;
+				DROP TABLE IF EXISTS partial_b;
+				DROP TABLE IF EXISTS ` + defaultTableName + `;`); err != nil {
+		t.Fatal(err)
+	}
+
+	files := []file.File{
+		{
+			Path:      "/foobar",
+			FileName:  "001_a.up.sql",
+			Version:   1,
+			Name:      "a",
+			Direction: direction.Up,
+			Content:   []byte(`CREATE TABLE partial_a (id int);`),
+		},
+		{
+			Path:      "/foobar",
+			FileName:  "002_b.up.sql",
+			Version:   2,
+			Name:      "b",
+			Direction: direction.Up,
+			Content:   []byte(`CREATE TABLE partial_b (id int); THIS WILL CAUSE AN ERROR;`),
+		},
+	}
+
+	d := &SingleTxnDriver{partial: true}
+	if err := d.Initialize(driverUrl); err != nil {
+		t.Fatal(err)
+	}
+	pipe := pipep.New()
+	go d.Migrate(files[0], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	pipe = pipep.New()
+	go d.Migrate(files[1], pipe)
+	if errs := pipep.ReadErrors(pipe); len(errs) == 0 {
+		t.Error("Expected test case to fail")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first file is committed, the failed one is not
+	var version uint64
+	if err := connection.QueryRow("SELECT max(version) FROM " + defaultTableName).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("expected version 1, got %v", version)
+	}
+	var exists bool
+	if err := connection.QueryRow("SELECT to_regclass('partial_b') IS NOT NULL").Scan(&exists); err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("expected partial_b to be rolled back")
+	}
+}
+
+func TestFailureReport(t *testing.T) {
+	failed := file.File{FileName: "003_c.up.sql"}
+	applied := []file.File{{FileName: "001_a.up.sql"}, {FileName: "002_b.up.sql"}}
+
+	tests := []struct {
+		applied  []file.File
+		commit   bool
+		expected string
+	}{
+		{nil, false, "003_c.up.sql failed and was rolled back"},
+		{applied[:1], false, "003_c.up.sql failed and was rolled back, rolling back the 1 file before it: 001_a.up.sql"},
+		{applied, false, "003_c.up.sql failed and was rolled back, rolling back the 2 files before it: 001_a.up.sql..002_b.up.sql"},
+		{applied, true, "003_c.up.sql failed and was rolled back, committing the 2 files before it: 001_a.up.sql..002_b.up.sql"},
+	}
+	for _, tt := range tests {
+		if report := failureReport(failed, tt.applied, tt.commit); report != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, report)
+		}
+	}
+}
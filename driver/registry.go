@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory returns a new, uninitialized Driver for the given transaction
+// strategy. Drivers that do not support multiple strategies may ignore it.
+type Factory func(txnType TxnType) Driver
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a driver available under the given url scheme.
+// It is meant to be called from the init function of a driver package.
+// Register panics if it is called twice with the same scheme or if
+// factory is nil.
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if factory == nil {
+		panic("driver: Register factory is nil")
+	}
+	if _, dup := registry[scheme]; dup {
+		panic(fmt.Sprintf("driver: Register called twice for scheme '%s'", scheme))
+	}
+	registry[scheme] = factory
+}
+
+// List returns the sorted url schemes of all registered drivers.
+func List() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	schemes := make([]string, 0, len(registry))
+	for scheme := range registry {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// lookup returns the factory registered for scheme.
+func lookup(scheme string) (Factory, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	factory, ok := registry[scheme]
+	return factory, ok
+}
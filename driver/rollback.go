@@ -0,0 +1,9 @@
+package driver
+
+// Rollbacker is implemented by drivers that run all files in a single
+// transaction and can roll it back on Close instead of committing it,
+// e.g. to prove that migrations work without applying them.
+type Rollbacker interface {
+	// RollbackOnClose makes Close roll back the transaction.
+	RollbackOnClose()
+}
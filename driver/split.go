@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Statement is a single statement of a migration file, for drivers that
+// split files and send one statement at a time.
+type Statement struct {
+	// Query is sent to the server, without its delimiter
+	Query string
+
+	// Offset is the byte offset of Query in the file content
+	Offset int
+}
+
+// NewStatement returns the statement in content[start:end] without the
+// whitespace around it.
+func NewStatement(content []byte, start, end int) Statement {
+	raw := content[start:end]
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	return Statement{
+		Query:  string(bytes.TrimRight(trimmed, " \t\r\n")),
+		Offset: start + len(raw) - len(trimmed),
+	}
+}
+
+// LineEnd returns the index of the newline ending the line at i, or the
+// end of content.
+func LineEnd(content []byte, i int) int {
+	if end := bytes.IndexByte(content[i:], '\n'); end >= 0 {
+		return i + end
+	}
+	return len(content)
+}
+
+// SyntaxError reports a problem at offset of content, found while
+// splitting it into statements.
+func SyntaxError(content []byte, offset int, message string) *MigrationError {
+	e := &MigrationError{Message: message}
+	e.SetPosition(content, offset)
+	return e
+}
+
+// StatementOffset returns the offset in query of the 1-based lineNo, as
+// reported by the server for an error in the statement. It is the start
+// of the last line if query is shorter.
+func StatementOffset(query string, lineNo int) int {
+	offset := 0
+	for i := 1; i < lineNo; i++ {
+		next := strings.IndexByte(query[offset:], '\n')
+		if next < 0 {
+			break
+		}
+		offset += next + 1
+	}
+	return offset
+}
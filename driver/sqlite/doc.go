@@ -0,0 +1,6 @@
+// Package sqlite implements the Driver interface.
+//
+// go-sqlite3 needs cgo, so the driver is only registered for the sqlite
+// and sqlite3 schemes when cgo is enabled. Builds with CGO_ENABLED=0,
+// like the Docker image, leave it out.
+package sqlite
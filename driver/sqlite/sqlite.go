@@ -0,0 +1,487 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	migratedriver "github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+	"github.com/promoboxx/migrate/migrate/direction"
+)
+
+type PerFileTxnDriver struct {
+	db *sql.DB
+
+	// quoted name of the version table
+	tableName string
+}
+
+type NoTxnDriver struct {
+	PerFileTxnDriver
+}
+
+type SingleTxnDriver struct {
+	PerFileTxnDriver
+	rollback bool
+	txn      *sql.Tx
+}
+
+const defaultTableName = "schema_migrations"
+
+// metadataColumns are appended to the version table. SQLite only adds
+// NOT NULL columns with a default, so all but dirty are nullable.
+var metadataColumns = []string{
+	"dirty boolean not null default false",
+	"file_name text",
+	"name text",
+	"applied_at timestamp",
+	"duration_ms integer",
+	"checksum text",
+	"host text",
+	"os_user text",
+	"tool_version text",
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func init() {
+	factory := func(txnType migratedriver.TxnType) migratedriver.Driver {
+		switch txnType {
+		case migratedriver.TxnNone:
+			log.Println("Migration scripts will be executed with no explicit transactions")
+			return &NoTxnDriver{}
+		case migratedriver.TxnSingle:
+			log.Println("All migration scripts will be executed in a single transaction")
+			return &SingleTxnDriver{}
+		default:
+			log.Println("Each migration script will be executed in its own transaction")
+			return &PerFileTxnDriver{}
+		}
+	}
+	migratedriver.Register("sqlite", factory)
+	migratedriver.Register("sqlite3", factory)
+}
+
+// SQLite Driver URL format:
+// sqlite://path/to/database.db
+// sqlite:///absolute/path/to/database.db
+// sqlite://:memory:
+//
+// Anything after the scheme is handed to go-sqlite3 unchanged, so its
+// DSN options (e.g. ?_foreign_keys=1) can be appended. The exception is
+// x-migrations-table, which names the version table and is removed.
+func (driver *PerFileTxnDriver) Initialize(url string) error {
+	return driver.InitializeContext(context.Background(), url)
+}
+
+func (driver *PerFileTxnDriver) InitializeContext(ctx context.Context, url string) error {
+	dsn, table, err := parseURL(url)
+	if err != nil {
+		return err
+	}
+	driver.tableName = quoteIdentifier(table)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return err
+	}
+	// Every connection to :memory: opens its own empty database, and SQLite
+	// only allows one writer anyway, so stick to a single connection.
+	db.SetMaxOpenConns(1)
+	if err := db.PingContext(ctx); err != nil {
+		return err
+	}
+	driver.db = db
+
+	if err := driver.ensureVersionTableExists(ctx); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseURL returns the go-sqlite3 DSN and the version table name.
+func parseURL(url string) (string, string, error) {
+	for _, scheme := range []string{"sqlite://", "sqlite3://"} {
+		if strings.HasPrefix(url, scheme) {
+			dsn, options, err := migratedriver.ExtractOptions(strings.TrimPrefix(url, scheme))
+			if err != nil {
+				return "", "", err
+			}
+			if dsn == "" {
+				return "", "", errors.New("missing database path in sqlite url")
+			}
+			return dsn, options.Get("x-migrations-table", defaultTableName), nil
+		}
+	}
+	return "", "", errors.New("invalid sqlite:// scheme")
+}
+
+func (driver *PerFileTxnDriver) Close() error {
+	if err := driver.db.Close(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (driver *PerFileTxnDriver) ensureVersionTableExists(ctx context.Context) error {
+	if _, err := driver.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+driver.tableName+" (version integer not null primary key, "+strings.Join(metadataColumns, ", ")+");"); err != nil {
+		return err
+	}
+
+	// PRAGMA table_info lists the columns a table of an older version has
+	existing := make(map[string]bool)
+	rows, err := driver.db.QueryContext(ctx, "PRAGMA table_info("+driver.tableName+")")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		existing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, column := range metadataColumns {
+		if existing[strings.Fields(column)[0]] {
+			continue
+		}
+		if _, err := driver.db.ExecContext(ctx, "ALTER TABLE "+driver.tableName+" ADD COLUMN "+column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (driver *PerFileTxnDriver) FilenameExtension() string {
+	return "sql"
+}
+
+func (driver *PerFileTxnDriver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+// MigrateContext runs f in a transaction, unless f has the
+// no-transaction directive.
+func (driver *PerFileTxnDriver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	pipe <- f
+
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+	directives, err := migratedriver.ReadDirectives(driver, f)
+	if err != nil {
+		pipe <- err
+		return
+	}
+	if directives.NoTransaction {
+		if err := migrate(ctx, driver.db, driver.tableName, f); err != nil {
+			pipe <- err
+		}
+		return
+	}
+
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		pipe <- err
+		return
+	}
+
+	if err := migrate(ctx, tx, driver.tableName, f); err != nil {
+		pipe <- err
+		if err := tx.Rollback(); err != nil {
+			pipe <- err
+		}
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		pipe <- err
+		return
+	}
+}
+
+// ValidateDirectives supports no-transaction only. SQLite has no
+// statement or lock timeouts per file.
+func (driver *PerFileTxnDriver) ValidateDirectives(d file.Directives) error {
+	return d.CheckSupported(file.NoTransaction)
+}
+
+func (driver *PerFileTxnDriver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *PerFileTxnDriver) VersionContext(ctx context.Context) (uint64, error) {
+	return version(ctx, driver.db, driver.tableName)
+}
+
+func (driver *PerFileTxnDriver) History(ctx context.Context) ([]migratedriver.AppliedMigration, error) {
+	return history(ctx, driver.db, driver.tableName)
+}
+
+func (driver *PerFileTxnDriver) Dirty(ctx context.Context) (uint64, bool, error) {
+	return dirty(ctx, driver.db, driver.tableName)
+}
+
+func (driver *PerFileTxnDriver) Force(ctx context.Context, version uint64) error {
+	tx, err := driver.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := force(ctx, tx, driver.tableName, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (driver *NoTxnDriver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+func (driver *NoTxnDriver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	pipe <- f
+
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		return
+	}
+	if _, err := migratedriver.ReadDirectives(driver, f); err != nil {
+		pipe <- err
+		return
+	}
+	if err := migrate(ctx, driver.db, driver.tableName, f); err != nil {
+		pipe <- err
+		return
+	}
+}
+
+func (driver *SingleTxnDriver) Initialize(url string) error {
+	return driver.InitializeContext(context.Background(), url)
+}
+
+func (driver *SingleTxnDriver) InitializeContext(ctx context.Context, url string) error {
+	err := driver.PerFileTxnDriver.InitializeContext(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	driver.txn, err = driver.db.Begin()
+	return err
+}
+
+func (driver *SingleTxnDriver) Close() error {
+	var err error
+	if driver.rollback {
+		err = driver.txn.Rollback()
+	} else {
+		err = driver.txn.Commit()
+	}
+
+	if err != nil {
+		driver.PerFileTxnDriver.Close()
+		return err
+	}
+
+	return driver.PerFileTxnDriver.Close()
+}
+
+// RollbackOnClose makes Close roll back the transaction, even if all
+// files succeeded.
+func (driver *SingleTxnDriver) RollbackOnClose() {
+	driver.rollback = true
+}
+
+func (driver *SingleTxnDriver) Migrate(f file.File, pipe chan interface{}) {
+	driver.MigrateContext(context.Background(), f, pipe)
+}
+
+func (driver *SingleTxnDriver) MigrateContext(ctx context.Context, f file.File, pipe chan interface{}) {
+	defer close(pipe)
+	pipe <- f
+
+	if err := f.ReadContent(); err != nil {
+		pipe <- err
+		driver.rollback = true
+		return
+	}
+	if _, err := migratedriver.ReadDirectives(driver, f); err != nil {
+		pipe <- err
+		driver.rollback = true
+		return
+	}
+	if err := migrate(ctx, driver.txn, driver.tableName, f); err != nil {
+		pipe <- err
+		driver.rollback = true
+		return
+	}
+}
+
+// Version reads through the open transaction; the only connection
+// is held by it.
+func (driver *SingleTxnDriver) Version() (uint64, error) {
+	return driver.VersionContext(context.Background())
+}
+
+func (driver *SingleTxnDriver) VersionContext(ctx context.Context) (uint64, error) {
+	return version(ctx, driver.txn, driver.tableName)
+}
+
+func (driver *SingleTxnDriver) History(ctx context.Context) ([]migratedriver.AppliedMigration, error) {
+	return history(ctx, driver.txn, driver.tableName)
+}
+
+func (driver *SingleTxnDriver) Dirty(ctx context.Context) (uint64, bool, error) {
+	return dirty(ctx, driver.txn, driver.tableName)
+}
+
+func (driver *SingleTxnDriver) Force(ctx context.Context, version uint64) error {
+	return force(ctx, driver.txn, driver.tableName, version)
+}
+
+// ValidateDirectives rejects all directives, as all files share one
+// transaction.
+func (driver *SingleTxnDriver) ValidateDirectives(d file.Directives) error {
+	return d.CheckSupported()
+}
+
+// migrate runs the file content and updates the version table.
+// The version is flagged as dirty while the file runs, which only
+// persists without a transaction. Always run files are not recorded
+// in the version table.
+func migrate(ctx context.Context, e execer, tableName string, f file.File) error {
+	if err := f.ReadContent(); err != nil {
+		return err
+	}
+
+	if f.Always == false {
+		if f.Direction == direction.Up {
+			if _, err := e.ExecContext(ctx, "INSERT INTO "+tableName+" (version, dirty) VALUES (?, true)", f.Version); err != nil {
+				return err
+			}
+		} else if f.Direction == direction.Down {
+			if _, err := e.ExecContext(ctx, "UPDATE "+tableName+" SET dirty = true WHERE version=?", f.Version); err != nil {
+				return err
+			}
+		}
+	}
+
+	started := time.Now()
+	if _, err := e.ExecContext(ctx, string(f.Content)); err != nil {
+		e := migratedriver.NewMigrationError(f, err)
+		if sqliteErr, ok := err.(sqlite3.Error); ok {
+			e.Code = strconv.Itoa(int(sqliteErr.ExtendedCode))
+		}
+		return e
+	}
+
+	if f.Always == false {
+		if f.Direction == direction.Up {
+			m := migratedriver.NewAppliedMigration(f, started)
+			if _, err := e.ExecContext(ctx, "UPDATE "+tableName+" SET dirty = false, file_name = ?, name = ?, applied_at = ?, duration_ms = ?, "+
+				"checksum = ?, host = ?, os_user = ?, tool_version = ? WHERE version = ?",
+				m.FileName, m.Name, m.AppliedAt, m.DurationMs(), m.Checksum, m.Host, m.User, m.ToolVersion, m.Version); err != nil {
+				return err
+			}
+		} else if f.Direction == direction.Down {
+			if _, err := e.ExecContext(ctx, "DELETE FROM "+tableName+" WHERE version=?", f.Version); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// history reads the clean rows of the version table.
+func history(ctx context.Context, e execer, tableName string) ([]migratedriver.AppliedMigration, error) {
+	rows, err := e.QueryContext(ctx, "SELECT version, file_name, name, applied_at, duration_ms, checksum, host, os_user, tool_version FROM "+tableName+" WHERE NOT dirty ORDER BY version ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make([]migratedriver.AppliedMigration, 0)
+	for rows.Next() {
+		var m migratedriver.AppliedMigration
+		var fileName, name, checksum, host, user, toolVersion sql.NullString
+		var appliedAt sql.NullTime
+		var durationMs sql.NullInt64
+		if err := rows.Scan(&m.Version, &fileName, &name, &appliedAt, &durationMs, &checksum, &host, &user, &toolVersion); err != nil {
+			return nil, err
+		}
+		m.FileName, m.Name, m.Checksum = fileName.String, name.String, checksum.String
+		m.Host, m.User, m.ToolVersion = host.String, user.String, toolVersion.String
+		m.AppliedAt = appliedAt.Time
+		m.Duration = time.Duration(durationMs.Int64) * time.Millisecond
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+func dirty(ctx context.Context, e execer, tableName string) (uint64, bool, error) {
+	var version uint64
+	err := e.QueryRowContext(ctx, "SELECT version FROM "+tableName+" WHERE dirty ORDER BY version DESC LIMIT 1").Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return version, true, nil
+	}
+}
+
+func force(ctx context.Context, e execer, tableName string, version uint64) error {
+	if _, err := e.ExecContext(ctx, "DELETE FROM "+tableName+" WHERE version > ?", version); err != nil {
+		return err
+	}
+	if _, err := e.ExecContext(ctx, "UPDATE "+tableName+" SET dirty = false"); err != nil {
+		return err
+	}
+	if version > 0 {
+		if _, err := e.ExecContext(ctx, "INSERT OR IGNORE INTO "+tableName+" (version) VALUES (?)", version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func version(ctx context.Context, e execer, tableName string) (uint64, error) {
+	var version uint64
+	err := e.QueryRowContext(ctx, "SELECT version FROM "+tableName+" ORDER BY version DESC LIMIT 1").Scan(&version)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, nil
+	case err != nil:
+		return 0, err
+	default:
+		return version, nil
+	}
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
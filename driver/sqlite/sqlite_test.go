@@ -0,0 +1,297 @@
+//go:build cgo
+
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+	"github.com/promoboxx/migrate/migrate/direction"
+	pipep "github.com/promoboxx/migrate/pipe"
+)
+
+var files = []file.File{
+	{
+		Path:      "/foobar",
+		FileName:  "001_foobar.up.sql",
+		Version:   1,
+		Name:      "foobar",
+		Direction: direction.Up,
+		Content: []byte(`
+			CREATE TABLE yolo (
+				id integer not null primary key
+			);
+			INSERT INTO yolo (id) VALUES (1);
+		`),
+	},
+	{
+		Path:      "/foobar",
+		FileName:  "001_foobar.down.sql",
+		Version:   1,
+		Name:      "foobar",
+		Direction: direction.Down,
+		Content: []byte(`
+			DROP TABLE yolo;
+		`),
+	},
+	{
+		Path:      "/foobar",
+		FileName:  "002_foobar.up.sql",
+		Version:   2,
+		Name:      "foobar",
+		Direction: direction.Up,
+		Content: []byte(`
+			CREATE TABLE error (
+				id integer not null primary key
+			);
+			INSERT INTO this_will_cause_an_error (id) VALUES (1);
+		`),
+	},
+	{
+		Path:      "/foobar",
+		FileName:  "003_views.alwaysup.sql",
+		Version:   3,
+		Name:      "views",
+		Direction: direction.Up,
+		Always:    true,
+		Content: []byte(`
+			DROP VIEW IF EXISTS yolo_view;
+			CREATE VIEW yolo_view AS SELECT id FROM yolo;
+		`),
+	},
+}
+
+func migrateFile(d interface {
+	Migrate(file.File, chan interface{})
+}, f file.File) []error {
+	pipe := pipep.New()
+	go d.Migrate(f, pipe)
+	return pipep.ReadErrors(pipe)
+}
+
+func expectVersion(t *testing.T, d interface{ Version() (uint64, error) }, expected uint64) {
+	version, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("Expected version %v, got %v", expected, version)
+	}
+}
+
+// TestMigrate runs some additional tests on Migrate().
+// Basic testing is already done in migrate/migrate_test.go
+func TestMigrate(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	d := &PerFileTxnDriver{}
+	if err := d.Initialize("sqlite://" + path.Join(tmpdir, "test.db")); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := migrateFile(d, files[0]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	expectVersion(t, d, 1)
+
+	// always run files are not recorded, so running them twice is fine
+	for i := 0; i < 2; i++ {
+		if errs := migrateFile(d, files[3]); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+	}
+	expectVersion(t, d, 1)
+
+	errs := migrateFile(d, files[2])
+	var migrationErr *driver.MigrationError
+	if len(errs) == 0 {
+		t.Error("Expected test case to fail")
+	} else if !errors.As(errs[0], &migrationErr) || migrationErr.Version != 2 || migrationErr.Code == "" {
+		t.Errorf("expected a *driver.MigrationError for version 2 with a code, got %#v", errs[0])
+	}
+	expectVersion(t, d, 1)
+
+	if errs := migrateFile(d, file.File{FileName: "003_foobar.down.sql", Version: 3, Direction: direction.Down, Always: true, Content: []byte("DROP VIEW yolo_view;")}); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if errs := migrateFile(d, files[1]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	expectVersion(t, d, 0)
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateMemory(t *testing.T) {
+	d := &NoTxnDriver{}
+	if err := d.Initialize("sqlite://:memory:"); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := migrateFile(d, files[0]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	expectVersion(t, d, 1)
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSingleTxnRollback(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	url := "sqlite://" + path.Join(tmpdir, "test.db")
+
+	d := &SingleTxnDriver{}
+	if err := d.Initialize(url); err != nil {
+		t.Fatal(err)
+	}
+	if errs := migrateFile(d, files[0]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	expectVersion(t, d, 1)
+	if errs := migrateFile(d, files[2]); len(errs) == 0 {
+		t.Error("Expected test case to fail")
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed file must have rolled back the first one as well
+	d2 := &PerFileTxnDriver{}
+	if err := d2.Initialize(url); err != nil {
+		t.Fatal(err)
+	}
+	expectVersion(t, d2, 0)
+	if err := d2.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	d := &NoTxnDriver{}
+	if err := d.Initialize("sqlite://:memory:"); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := migrateFile(d, files[0]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, dirty, err := d.Dirty(context.Background()); err != nil || dirty {
+		t.Fatalf("Expected clean database, got %v (%v)", dirty, err)
+	}
+
+	// without a transaction the failed file leaves table error behind
+	if errs := migrateFile(d, files[2]); len(errs) == 0 {
+		t.Error("Expected test case to fail")
+	}
+	version, dirty, err := d.Dirty(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dirty || version != 2 {
+		t.Fatalf("Expected dirty version 2, got %v %v", dirty, version)
+	}
+
+	if err := d.Force(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, dirty, err := d.Dirty(context.Background()); err != nil || dirty {
+		t.Fatalf("Expected clean database, got %v (%v)", dirty, err)
+	}
+	expectVersion(t, d, 1)
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrationsTable(t *testing.T) {
+	d := &PerFileTxnDriver{}
+	if err := d.Initialize("sqlite://:memory:?x-migrations-table=my_versions"); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := migrateFile(d, files[0]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	expectVersion(t, d, 1)
+
+	var count int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM my_versions").Scan(&count); err != nil || count != 1 {
+		t.Fatalf("Expected 1 row in my_versions, got %v (%v)", count, err)
+	}
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = ?", defaultTableName).Scan(&count); err != nil || count != 0 {
+		t.Fatalf("Expected no %v table, got %v (%v)", defaultTableName, count, err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("/tmp", "migrate-sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	url := "sqlite://" + path.Join(tmpdir, "test.db")
+
+	// a version table as written by older versions
+	db, err := sql.Open("sqlite3", path.Join(tmpdir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE schema_migrations (version integer not null primary key, dirty boolean not null default false); INSERT INTO schema_migrations (version) VALUES (0);"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	d := &PerFileTxnDriver{}
+	if err := d.Initialize(url); err != nil {
+		t.Fatal(err)
+	}
+	if errs := migrateFile(d, files[0]); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+
+	var fileName, name, checksum, host, user, toolVersion string
+	var appliedAt time.Time
+	var durationMs int64
+	if err := d.db.QueryRow("SELECT file_name, name, applied_at, duration_ms, checksum, host, os_user, tool_version FROM schema_migrations WHERE version = 1").
+		Scan(&fileName, &name, &appliedAt, &durationMs, &checksum, &host, &user, &toolVersion); err != nil {
+		t.Fatal(err)
+	}
+	if fileName != files[0].FileName || name != files[0].Name {
+		t.Errorf("Expected %v and %v, got %v and %v", files[0].FileName, files[0].Name, fileName, name)
+	}
+	if checksum != files[0].Checksum() {
+		t.Errorf("Expected checksum %v, got %v", files[0].Checksum(), checksum)
+	}
+	if time.Since(appliedAt) > time.Minute || durationMs < 0 || host == "" || user == "" || toolVersion == "" {
+		t.Errorf("Unexpected metadata %v %v %v %v %v", appliedAt, durationMs, host, user, toolVersion)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,17 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/promoboxx/migrate/file"
+)
+
+// VersionTableUpgrader is implemented by drivers that have to convert
+// the version table of older versions of migrate before they can record
+// versions. It is only called while holding the lock, and only by
+// commands that migrate, so that reading the version never changes the
+// database. The migration files let the driver check what the old table
+// implies.
+type VersionTableUpgrader interface {
+	UpgradeVersionTable(ctx context.Context, files file.MigrationFiles) error
+}
@@ -0,0 +1,151 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Names of the directives, as written after "-- migrate:".
+const (
+	NoTransaction    = "no-transaction"
+	StatementTimeout = "statement_timeout"
+	LockTimeout      = "lock_timeout"
+	Set              = "set"
+)
+
+const directivePrefix = "migrate:"
+
+// settingRegex matches the name of a session setting.
+var settingRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// Directives change how a driver runs a single file. They are written as
+// comments in the header of the file, before the first statement:
+//
+//	-- migrate:no-transaction
+//	-- migrate:statement_timeout=30s
+//	-- migrate:lock_timeout=5s
+//	-- migrate:set search_path=app
+type Directives struct {
+	// NoTransaction runs the file outside of a transaction, e.g. for
+	// CREATE INDEX CONCURRENTLY.
+	NoTransaction bool
+
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+
+	// Settings are session settings, in the order of the file.
+	Settings []Setting
+}
+
+// Setting is a session setting of a set directive.
+type Setting struct {
+	Name  string
+	Value string
+}
+
+// Names returns the names of the directives that are set.
+func (d Directives) Names() []string {
+	names := make([]string, 0)
+	if d.NoTransaction {
+		names = append(names, NoTransaction)
+	}
+	if d.StatementTimeout > 0 {
+		names = append(names, StatementTimeout)
+	}
+	if d.LockTimeout > 0 {
+		names = append(names, LockTimeout)
+	}
+	if len(d.Settings) > 0 {
+		names = append(names, Set)
+	}
+	return names
+}
+
+// CheckSupported returns an error for the first directive that is set
+// but not one of supported.
+func (d Directives) CheckSupported(supported ...string) error {
+	for _, name := range d.Names() {
+		found := false
+		for _, s := range supported {
+			found = found || s == name
+		}
+		if !found {
+			return fmt.Errorf("directive %s%s is not supported here", directivePrefix, name)
+		}
+	}
+	return nil
+}
+
+// Directives parses the directives in the header of the file.
+// Call ReadContent first.
+func (f *File) Directives() (Directives, error) {
+	return ParseDirectives(f.Content)
+}
+
+// ParseDirectives parses the directives in the header of content, i.e. the
+// lines before the first one that is neither empty nor a -- comment.
+// Unknown directives are an error.
+func ParseDirectives(content []byte) (Directives, error) {
+	var d Directives
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		text := strings.TrimSpace(string(line))
+		if text == "" {
+			continue
+		}
+		if !strings.HasPrefix(text, "--") {
+			break
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, "--"))
+		if !strings.HasPrefix(text, directivePrefix) {
+			continue
+		}
+		if err := d.parse(strings.TrimPrefix(text, directivePrefix)); err != nil {
+			return Directives{}, fmt.Errorf("%v in line %v", err, i+1)
+		}
+	}
+	return d, nil
+}
+
+func (d *Directives) parse(directive string) error {
+	name, value := directive, ""
+	if i := strings.IndexAny(directive, "= "); i >= 0 {
+		name, value = directive[:i], strings.TrimSpace(directive[i+1:])
+	}
+
+	switch name {
+	case NoTransaction:
+		if value != "" {
+			return fmt.Errorf("directive %s%s takes no value", directivePrefix, name)
+		}
+		d.NoTransaction = true
+
+	case StatementTimeout, LockTimeout:
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("directive %s%s needs a positive duration, e.g. 30s", directivePrefix, name)
+		}
+		if name == StatementTimeout {
+			d.StatementTimeout = timeout
+		} else {
+			d.LockTimeout = timeout
+		}
+
+	case Set:
+		i := strings.Index(value, "=")
+		if i < 0 {
+			return fmt.Errorf("directive %s%s needs name=value", directivePrefix, name)
+		}
+		setting := Setting{Name: strings.TrimSpace(value[:i]), Value: strings.TrimSpace(value[i+1:])}
+		if !settingRegex.MatchString(setting.Name) || setting.Value == "" {
+			return fmt.Errorf("directive %s%s needs name=value", directivePrefix, name)
+		}
+		d.Settings = append(d.Settings, setting)
+
+	default:
+		return fmt.Errorf("unknown directive %s%s", directivePrefix, name)
+	}
+	return nil
+}
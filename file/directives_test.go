@@ -0,0 +1,61 @@
+package file
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseDirectives(t *testing.T) {
+	content := `
+-- Adds the index without locking the table.
+-- migrate:no-transaction
+--migrate:statement_timeout=30s
+-- migrate:lock_timeout=5s
+-- migrate:set search_path=app, public
+-- migrate:set work_mem = 64MB
+
+CREATE INDEX CONCURRENTLY a_b ON a (b);
+-- migrate:unknown is not in the header
+`
+	d, err := ParseDirectives([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Directives{
+		NoTransaction:    true,
+		StatementTimeout: 30 * time.Second,
+		LockTimeout:      5 * time.Second,
+		Settings:         []Setting{{"search_path", "app, public"}, {"work_mem", "64MB"}},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Errorf("expected %+v, got %+v", expected, d)
+	}
+	if err := d.CheckSupported(NoTransaction, StatementTimeout, LockTimeout, Set); err != nil {
+		t.Error(err)
+	}
+	if err := d.CheckSupported(NoTransaction); err == nil || err.Error() != "directive migrate:statement_timeout is not supported here" {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	d, err = ParseDirectives([]byte("CREATE TABLE a (id int);"))
+	if err != nil || len(d.Names()) != 0 {
+		t.Errorf("expected no directives, got %+v (%v)", d, err)
+	}
+
+	tests := []struct {
+		content string
+		message string
+	}{
+		{"-- migrate:no-transactions\n", "unknown directive migrate:no-transactions in line 1"},
+		{"\n-- migrate:no-transaction=yes\n", "directive migrate:no-transaction takes no value in line 2"},
+		{"-- migrate:statement_timeout=soon\n", "directive migrate:statement_timeout needs a positive duration, e.g. 30s in line 1"},
+		{"-- migrate:set search_path\n", "directive migrate:set needs name=value in line 1"},
+		{"-- migrate:set a;b=c\n", "directive migrate:set needs name=value in line 1"},
+	}
+	for _, test := range tests {
+		if _, err := ParseDirectives([]byte(test.content)); err == nil || err.Error() != test.message {
+			t.Errorf("%q: expected %q, got %v", test.content, test.message, err)
+		}
+	}
+}
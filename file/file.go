@@ -3,10 +3,14 @@ package file
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"go/token"
+	"io/fs"
 	"io/ioutil"
+	"os"
 	"path"
 	"regexp"
 	"sort"
@@ -24,12 +28,15 @@ func FilenameRegex(filenameExtension string) *regexp.Regexp {
 	return regexp.MustCompile(fmt.Sprintf(filenameRegex, filenameExtension))
 }
 
-// File represents one file on disk.
+// File represents one file on disk or in a fs.FS.
 // Example: 001_initial_plan_to_do_sth.up.sql
 type File struct {
-	// absolute path to file
+	// absolute path to file, empty for files read from FS
 	Path string
 
+	// FS the file is read from, nil for files on disk
+	FS fs.FS
+
 	// the name of the file
 	FileName string
 
@@ -74,7 +81,13 @@ type MigrationFiles []MigrationFile
 // ReadContent reads the file's content if the content is empty
 func (f *File) ReadContent() error {
 	if len(f.Content) == 0 {
-		content, err := ioutil.ReadFile(path.Join(f.Path, f.FileName))
+		var content []byte
+		var err error
+		if f.FS != nil {
+			content, err = fs.ReadFile(f.FS, f.FileName)
+		} else {
+			content, err = ioutil.ReadFile(path.Join(f.Path, f.FileName))
+		}
 		if err != nil {
 			return err
 		}
@@ -83,6 +96,13 @@ func (f *File) ReadContent() error {
 	return nil
 }
 
+// Checksum returns the hex encoded SHA-256 of the file's content.
+// Call ReadContent first.
+func (f *File) Checksum() string {
+	sum := sha256.Sum256(f.Content)
+	return hex.EncodeToString(sum[:])
+}
+
 // ToFirstFrom fetches all (down) migration files including the migration file
 // of the current version to the very first migration file.  It will also
 // include all alwaysdown files.
@@ -111,6 +131,47 @@ func (mf *MigrationFiles) ToLastFrom(version uint64) (Files, error) {
 	return files, nil
 }
 
+// Pending fetches all (up) migration files whose version is not in applied,
+// including versions below the most recently applied one.  It will also
+// include all alwaysup files.
+func (mf *MigrationFiles) Pending(applied map[uint64]bool) (Files, error) {
+	sort.Sort(mf)
+	files := make(Files, 0)
+	for _, migrationFile := range *mf {
+		if (migrationFile.Always || !applied[migrationFile.Version]) && migrationFile.UpFile != nil {
+			files = append(files, *migrationFile.UpFile)
+		}
+	}
+	return files, nil
+}
+
+// Applied fetches the (down) migration files of all versions in applied,
+// most recent first.  It will also include all alwaysdown files.
+func (mf *MigrationFiles) Applied(applied map[uint64]bool) (Files, error) {
+	sort.Sort(sort.Reverse(mf))
+	files := make(Files, 0)
+	for _, migrationFile := range *mf {
+		if (migrationFile.Always || applied[migrationFile.Version]) && migrationFile.DownFile != nil {
+			files = append(files, *migrationFile.DownFile)
+		}
+	}
+	return files, nil
+}
+
+// Missing fetches the (up) migration files below version whose version is
+// not in applied, i.e. migrations that were added after a more recent one
+// had been applied.  Always run files are never missing.
+func (mf *MigrationFiles) Missing(version uint64, applied map[uint64]bool) Files {
+	sort.Sort(mf)
+	files := make(Files, 0)
+	for _, migrationFile := range *mf {
+		if !migrationFile.Always && migrationFile.Version < version && !applied[migrationFile.Version] && migrationFile.UpFile != nil {
+			files = append(files, *migrationFile.UpFile)
+		}
+	}
+	return files
+}
+
 // From travels relatively through migration files.  It will include the set
 // of always run files.  The always run files do not count as a version.
 //
@@ -168,8 +229,20 @@ func (mf *MigrationFiles) From(version uint64, relativeN int) (Files, error) {
 
 // ReadMigrationFiles reads all migration files from a given path
 func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
-	// find all migration files in path
-	ioFiles, err := ioutil.ReadDir(path)
+	return readMigrationFiles(os.DirFS(path), path, nil, filenameRegex)
+}
+
+// ReadMigrationFilesFS reads all migration files in the root directory
+// of fsys, e.g. an embed.FS. Use fs.Sub for files in a subdirectory.
+func ReadMigrationFilesFS(fsys fs.FS, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
+	return readMigrationFiles(fsys, "", fsys, filenameRegex)
+}
+
+// readMigrationFiles lists the migration files in the root of fsys. Their
+// Path and FS are set to path and contentFS.
+func readMigrationFiles(fsys fs.FS, path string, contentFS fs.FS, filenameRegex *regexp.Regexp) (files MigrationFiles, err error) {
+	// find all migration files in fsys
+	ioFiles, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return nil, err
 	}
@@ -213,6 +286,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 			case direction.Up:
 				migrationFile.UpFile = &File{
 					Path:      path,
+					FS:        contentFS,
 					FileName:  file.filename,
 					Version:   file.version,
 					Name:      file.name,
@@ -224,6 +298,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 			case direction.Down:
 				migrationFile.DownFile = &File{
 					Path:      path,
+					FS:        contentFS,
 					FileName:  file.filename,
 					Version:   file.version,
 					Name:      file.name,
@@ -242,6 +317,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 					case direction.Up:
 						migrationFile.UpFile = &File{
 							Path:      path,
+							FS:        contentFS,
 							FileName:  file2.filename,
 							Version:   file.version,
 							Name:      file2.name,
@@ -252,6 +328,7 @@ func ReadMigrationFiles(path string, filenameRegex *regexp.Regexp) (files Migrat
 					case direction.Down:
 						migrationFile.DownFile = &File{
 							Path:      path,
+							FS:        contentFS,
 							FileName:  file2.filename,
 							Version:   file.version,
 							Name:      file2.name,
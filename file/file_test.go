@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"testing/fstest"
 
 	"github.com/promoboxx/migrate/migrate/direction"
 )
@@ -240,4 +241,63 @@ func TestFiles(t *testing.T) {
 		t.Error("ToFirstFrom() did not return UpFiles")
 	}
 
+	// test Pending, Applied and Missing with version 2 applied after 101
+	applied := map[uint64]bool{1: true, 101: true}
+	pendingFiles, err := files.Pending(applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingFiles) != 3 || pendingFiles[0].Version != 2 || pendingFiles[1].Version != 10 || pendingFiles[2].Version != 301 {
+		t.Fatalf("Pending(): unexpected files %v", pendingFiles)
+	}
+	appliedFiles, err := files.Applied(applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(appliedFiles) != 2 || appliedFiles[0].Version != 101 || appliedFiles[1].Version != 1 || appliedFiles[0].Direction != direction.Down {
+		t.Fatalf("Applied(): unexpected files %v", appliedFiles)
+	}
+	missingFiles := files.Missing(101, applied)
+	if len(missingFiles) != 1 || missingFiles[0].Version != 2 {
+		t.Fatalf("Missing(): unexpected files %v", missingFiles)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	f := File{Content: []byte("CREATE TABLE t (id int);")}
+	a := f.Checksum()
+	if len(a) != 64 {
+		t.Fatalf("expected a hex encoded sha256, got %q", a)
+	}
+	f.Content = []byte("CREATE TABLE t (id bigint);")
+	if f.Checksum() == a {
+		t.Error("expected checksum to change with the content")
+	}
+}
+
+func TestReadMigrationFilesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"001_a.up.sql":   {Data: []byte("CREATE TABLE a (id int);")},
+		"001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"002_b.up.sql":   {Data: []byte("CREATE TABLE b (id int);")},
+	}
+
+	files, err := ReadMigrationFilesFS(fsys, FilenameRegex("sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Version != 1 || files[1].Version != 2 {
+		t.Fatalf("unexpected files %v", files)
+	}
+	if files[0].DownFile == nil || files[1].DownFile != nil {
+		t.Fatalf("unexpected down files %v", files)
+	}
+
+	f := files[1].UpFile
+	if err := f.ReadContent(); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.Content) != "CREATE TABLE b (id int);" {
+		t.Errorf("unexpected content %q", f.Content)
+	}
 }
@@ -1,17 +1,18 @@
 module github.com/promoboxx/migrate
 
-go 1.19
+go 1.20
 
 require (
+	github.com/aws/aws-sdk-go v1.43.43
 	github.com/fatih/color v1.16.0
 	github.com/go-sql-driver/mysql v1.2.1-0.20161020111133-dbc3fe23a4ac
 	github.com/gocql/gocql v1.6.0
 	github.com/healthimation/go-aws-config v0.0.0-20220508090657-1cc45644ff20
 	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
 )
 
 require (
-	github.com/aws/aws-sdk-go v1.43.43 // indirect
 	github.com/divideandconquer/go-consul-client v0.0.0-20210610174817-6146efeb9f54 // indirect
 	github.com/golang/snappy v0.0.3 // indirect
 	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
@@ -4,10 +4,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/fatih/color"
@@ -17,20 +20,68 @@ import (
 	"github.com/promoboxx/migrate/migrate"
 	"github.com/promoboxx/migrate/migrate/direction"
 	pipep "github.com/promoboxx/migrate/pipe"
+	"github.com/promoboxx/migrate/source"
 )
 
 const Version string = "1.2.0"
 
 var url = flag.String("url", "", "")
 var migrationsPath = flag.String("path", "", "")
+var sourceURL = flag.String("source", "", "Read the migrations from a url instead of -path, e.g. file:///migrations.tar.gz")
 var version = flag.Bool("version", false, "Show migrate version")
 var transactionType = flag.String("txn", "PerFile", "")
 var environment = flag.String("env", "", "The environment you're running in")
 var service = flag.String("service", "", "The service's name (combined with env to form AWS parameter store key \"/env/service/urlkey\")")
 var dbURLKey = flag.String("urlkey", "DB_URL", "")
+var timeout = flag.Duration("timeout", 0, "Abort the run after this duration, e.g. 10m")
+var lockTimeout = flag.Duration("lock-timeout", 5*time.Minute, "How long to wait for the migration lock held by another process")
+var dryRun dryRunFlag
+var allowOutOfOrder = flag.Bool("allow-out-of-order", false, "Apply migrations older than the current version")
+var allowModified = flag.Bool("allow-modified", false, "Run although applied migration files have been modified")
+
+func init() {
+	flag.Var(&dryRun, "dry-run", "Print the migrations that would run, or run them and roll back with -dry-run=execute")
+}
+
+// dryRunFlag is a boolean flag that also accepts "execute".
+type dryRunFlag struct {
+	mode migrate.DryRunMode
+}
+
+func (f *dryRunFlag) String() string {
+	switch f.mode {
+	case migrate.DryRunPrint:
+		return "true"
+	case migrate.DryRunExecute:
+		return "execute"
+	}
+	return "false"
+}
+
+func (f *dryRunFlag) Set(value string) error {
+	switch value {
+	case "execute":
+		f.mode = migrate.DryRunExecute
+		return nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("expected true, false or execute")
+	}
+	f.mode = migrate.NoDryRun
+	if enabled {
+		f.mode = migrate.DryRunPrint
+	}
+	return nil
+}
+
+func (f *dryRunFlag) IsBoolFlag() bool {
+	return true
+}
 
 func main() {
 	flag.Parse()
+	driver.ToolVersion = Version
 	command := flag.Arg(0)
 
 	if *version {
@@ -65,6 +116,46 @@ func main() {
 		url = &dbURL
 	}
 
+	migrate.SetLockTimeout(*lockTimeout)
+	migrate.SetAllowModified(*allowModified)
+	migrate.SetAllowOutOfOrder(*allowOutOfOrder)
+	migrate.SetDryRun(dryRun.mode)
+	switch command {
+	case "up", "down", "migrate", "goto", "redo", "reset":
+		switch dryRun.mode {
+		case migrate.DryRunPrint:
+			fmt.Println("Dry run, these migrations would run:")
+		case migrate.DryRunExecute:
+			fmt.Println("Dry run, all changes are rolled back:")
+		}
+	}
+
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	// -source replaces -path for the commands that read migration files
+	var src *source.Source
+	switch command {
+	case "up", "down", "migrate", "goto", "redo", "reset", "status", "verify":
+		if *sourceURL != "" {
+			src, err = source.Open(ctx, *sourceURL)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			fmt.Println("Source " + src.String())
+		}
+	case "create":
+		if *sourceURL != "" {
+			fmt.Println("create writes to -path and cannot be used with -source")
+			os.Exit(1)
+		}
+	}
+
 	switch command {
 	case "create":
 		verifyMigrationsPath(*migrationsPath)
@@ -94,7 +185,11 @@ func main() {
 		}
 		timerStart = time.Now()
 		pipe := pipep.New()
-		go migrate.Migrate(pipe, *url, *migrationsPath, relativeNInt, txnType)
+		if src != nil {
+			go migrate.MigrateFS(ctx, pipe, *url, src.FS, relativeNInt, txnType)
+		} else {
+			go migrate.MigrateContext(ctx, pipe, *url, *migrationsPath, relativeNInt, txnType)
+		}
 		ok := writePipe(pipe)
 		printTimer()
 		if !ok {
@@ -110,17 +205,13 @@ func main() {
 			os.Exit(1)
 		}
 
-		currentVersion, err := migrate.Version(*url, *migrationsPath, txnType)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		relativeNInt := toVersionInt - int(currentVersion)
-
 		timerStart = time.Now()
 		pipe := pipep.New()
-		go migrate.Migrate(pipe, *url, *migrationsPath, relativeNInt, txnType)
+		if src != nil {
+			go migrate.GotoFS(ctx, pipe, *url, src.FS, uint64(toVersionInt), txnType)
+		} else {
+			go migrate.GotoContext(ctx, pipe, *url, *migrationsPath, uint64(toVersionInt), txnType)
+		}
 		ok := writePipe(pipe)
 		printTimer()
 		if !ok {
@@ -131,7 +222,11 @@ func main() {
 		verifyMigrationsPath(*migrationsPath)
 		timerStart = time.Now()
 		pipe := pipep.New()
-		go migrate.Up(pipe, *url, *migrationsPath, txnType)
+		if src != nil {
+			go migrate.UpFS(ctx, pipe, *url, src.FS, txnType)
+		} else {
+			go migrate.UpContext(ctx, pipe, *url, *migrationsPath, txnType)
+		}
 		ok := writePipe(pipe)
 		printTimer()
 		if !ok {
@@ -142,7 +237,11 @@ func main() {
 		verifyMigrationsPath(*migrationsPath)
 		timerStart = time.Now()
 		pipe := pipep.New()
-		go migrate.Down(pipe, *url, *migrationsPath, txnType)
+		if src != nil {
+			go migrate.DownFS(ctx, pipe, *url, src.FS, txnType)
+		} else {
+			go migrate.DownContext(ctx, pipe, *url, *migrationsPath, txnType)
+		}
 		ok := writePipe(pipe)
 		printTimer()
 		if !ok {
@@ -153,7 +252,11 @@ func main() {
 		verifyMigrationsPath(*migrationsPath)
 		timerStart = time.Now()
 		pipe := pipep.New()
-		go migrate.Redo(pipe, *url, *migrationsPath, txnType)
+		if src != nil {
+			go migrate.RedoFS(ctx, pipe, *url, src.FS, txnType)
+		} else {
+			go migrate.RedoContext(ctx, pipe, *url, *migrationsPath, txnType)
+		}
 		ok := writePipe(pipe)
 		printTimer()
 		if !ok {
@@ -164,13 +267,66 @@ func main() {
 		verifyMigrationsPath(*migrationsPath)
 		timerStart = time.Now()
 		pipe := pipep.New()
-		go migrate.Reset(pipe, *url, *migrationsPath, txnType)
+		if src != nil {
+			go migrate.ResetFS(ctx, pipe, *url, src.FS, txnType)
+		} else {
+			go migrate.ResetContext(ctx, pipe, *url, *migrationsPath, txnType)
+		}
 		ok := writePipe(pipe)
 		printTimer()
 		if !ok {
 			os.Exit(1)
 		}
 
+	case "force":
+		toVersion := flag.Arg(1)
+		toVersionInt, err := strconv.ParseUint(toVersion, 10, 64)
+		if err != nil {
+			fmt.Println("Unable to parse param <v>.")
+			os.Exit(1)
+		}
+		if err := migrate.ForceContext(ctx, *url, toVersionInt); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Version forced to %v\n", toVersionInt)
+
+	case "status":
+		verifyMigrationsPath(*migrationsPath)
+		var statuses []migrate.MigrationStatus
+		if src != nil {
+			statuses, err = migrate.StatusFS(ctx, *url, src.FS)
+		} else {
+			statuses, err = migrate.StatusContext(ctx, *url, *migrationsPath)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		printStatus(statuses)
+
+	case "verify":
+		verifyMigrationsPath(*migrationsPath)
+		var mismatches []migrate.ChecksumMismatch
+		if src != nil {
+			mismatches, err = migrate.VerifyFS(ctx, *url, src.FS)
+		} else {
+			mismatches, err = migrate.VerifyContext(ctx, *url, *migrationsPath)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(mismatches) == 0 {
+			fmt.Println("All applied migration files match their checksums")
+			break
+		}
+		c := color.New(color.FgRed)
+		for _, m := range mismatches {
+			c.Println(m.String())
+		}
+		os.Exit(1)
+
 	case "version":
 		verifyMigrationsPath(*migrationsPath)
 		version, err := migrate.Version(*url, *migrationsPath, txnType)
@@ -203,7 +359,8 @@ func writePipe(pipe chan interface{}) (ok bool) {
 
 					case error:
 						c := color.New(color.FgRed)
-						c.Println(item.(error).Error(), "\n")
+						c.Println(item.(error).Error())
+						fmt.Println()
 						okFlag = false
 
 					case file.File:
@@ -234,6 +391,36 @@ func verifyMigrationsPath(path string) {
 	}
 }
 
+func printStatus(statuses []migrate.MigrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT\tDOWN")
+	for _, s := range statuses {
+		status := "pending"
+		switch {
+		case s.Always && s.HasUp && s.HasDown:
+			status = "alwaysup, alwaysdown"
+		case s.Always && s.HasUp:
+			status = "alwaysup"
+		case s.Always:
+			status = "alwaysdown"
+		case s.NoFile:
+			status = "applied, no file on disk"
+		case s.Applied:
+			status = "applied"
+		}
+		appliedAt := ""
+		if !s.AppliedAt.IsZero() {
+			appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		down := "no"
+		if s.HasDown {
+			down = "yes"
+		}
+		fmt.Fprintf(w, "%v\t%s\t%s\t%s\t%s\n", s.Version, s.Name, status, appliedAt, down)
+	}
+	w.Flush()
+}
+
 var timerStart time.Time
 
 func printTimer() {
@@ -247,7 +434,7 @@ func printTimer() {
 
 func helpCmd() {
 	os.Stderr.WriteString(
-		`usage: migrate [-path=<path>] [-url=<url>] [-env=<environment> -service=<serviceName> [-urlkey=<urlkey>]] <command> [<args>]
+		`usage: migrate [-path=<path> | -source=<url>] [-url=<url>] [-env=<environment> -service=<serviceName> [-urlkey=<urlkey>]] [-timeout=<duration>] [-lock-timeout=<duration>] [-txn=<type>] [-allow-modified] [-allow-out-of-order] [-dry-run[=execute]] <command> [<args>]
 
 Commands:
    create <name>  Create a new migration
@@ -256,14 +443,34 @@ Commands:
    reset          Down followed by Up
    redo           Roll back most recent migration, then apply it again
    version        Show current migration version
+   status         List all migrations and whether and when they were applied
    migrate <n>    Apply migrations -n|+n
    goto <v>       Migrate to version v
+   force <v>      Set version v and clear the dirty flag after repairing a failed migration by hand
+   verify         List applied migration files that have been modified since
    help           Show this help
 
 '-path' defaults to current working directory.
+'-source' reads the migrations from a url instead, and prints it with the hash of the files:
+  file:///x (a directory, or a .tar.gz, .tgz, .tar or .zip archive), zip://x.zip,
+  s3://bucket/prefix?region=&endpoint=&path-style=true (objects below prefix, or an archive object),
+  git://host/repo?ref=v1.2.3 (also git+https, git+ssh and git+file).
+  '?path=dir' selects a directory within archives and repositories.
 '-url' or '-env' and '-service' are required.  
 If you provide '-env' and '-service' the app will look up the '-urlkey' in AWS parameter store as '/env/service/urlkey'
 You will need to provide a standard AWS credential provider to use the '-env' and '-service' parameters.
 '-urlkey' defaults to DB_URL
+'-timeout' aborts the run and rolls back the running migration once it expires, e.g. -timeout=10m
+'-txn' is PerFile (default), none, single or single-partial. single runs all files in one transaction,
+  single-partial commits the files before a failing one (postgres; other drivers run each file in its own transaction).
+'-lock-timeout' is how long up, down, migrate, goto, redo and reset wait for another
+  process holding the migration lock (postgres, mysql, cassandra). Defaults to 5m.
+'-allow-modified' runs although applied up files no longer match their recorded checksums.
+'-allow-out-of-order' lets up apply migrations older than the current version instead of failing.
+'-dry-run' prints the files up, down, migrate, goto, redo and reset would run, in order, without running them.
+  '-dry-run=execute' runs them in a single transaction and rolls it back (postgres, sqlite).
+
+Drivers: ` + strings.Join(driver.List(), ", ") + `
+  sqlite is only built in with cgo, so binaries built with CGO_ENABLED=0, like the Docker image, leave it out.
 `)
 }
@@ -0,0 +1,37 @@
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChecksumMismatch describes an up file that has been edited after it
+// was applied.
+type ChecksumMismatch struct {
+	Version  uint64
+	FileName string
+
+	// Applied is the checksum recorded when the file was applied,
+	// Current the checksum of the file on disk.
+	Applied string
+	Current string
+}
+
+func (m ChecksumMismatch) String() string {
+	return fmt.Sprintf("%s: applied with checksum %s, now %s", m.FileName, m.Applied, m.Current)
+}
+
+// ModifiedError is returned when a run is attempted while applied up
+// files have been edited. See SetAllowModified.
+type ModifiedError struct {
+	Mismatches []ChecksumMismatch
+}
+
+func (e *ModifiedError) Error() string {
+	lines := make([]string, 0, len(e.Mismatches))
+	for _, m := range e.Mismatches {
+		lines = append(lines, "  "+m.String())
+	}
+	return fmt.Sprintf("%v applied migration file(s) have been modified:\n%s\n"+
+		"Revert the changes and add a new migration instead, or run with -allow-modified.", len(e.Mismatches), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,42 @@
+package migrate
+
+import (
+	"errors"
+
+	"github.com/promoboxx/migrate/driver"
+)
+
+// DryRunMode selects what a dry run does instead of applying migrations.
+type DryRunMode int
+
+const (
+	// NoDryRun applies migrations.
+	NoDryRun DryRunMode = iota
+
+	// DryRunPrint sends the files that would run to the pipe, in order,
+	// without running them.
+	DryRunPrint
+
+	// DryRunExecute runs the files in a single transaction and rolls it
+	// back. Only drivers implementing driver.Rollbacker support it.
+	DryRunExecute
+)
+
+// dryRun is the mode of Up, Down, Migrate, Goto, Redo and Reset.
+var dryRun = NoDryRun
+
+// SetDryRun makes Up, Down, Migrate, Goto, Redo and Reset only show or try
+// what they would do.
+func SetDryRun(mode DryRunMode) {
+	dryRun = mode
+}
+
+// rollbackOnClose prepares d for a DryRunExecute run.
+func rollbackOnClose(d driver.Driver) error {
+	r, ok := driver.Unwrap(d).(driver.Rollbacker)
+	if !ok {
+		return errors.New("driver does not support dry run execute, as it cannot roll back all changes")
+	}
+	r.RollbackOnClose()
+	return nil
+}
@@ -3,6 +3,8 @@
 package migrate
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,22 +12,40 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/promoboxx/migrate/driver"
 	"github.com/promoboxx/migrate/file"
 	"github.com/promoboxx/migrate/migrate/direction"
 	pipep "github.com/promoboxx/migrate/pipe"
+
+	// built-in drivers register themselves with the driver package
+	_ "github.com/promoboxx/migrate/driver/bash"
+	_ "github.com/promoboxx/migrate/driver/cassandra"
+	_ "github.com/promoboxx/migrate/driver/mysql"
+	_ "github.com/promoboxx/migrate/driver/postgres"
+	_ "github.com/promoboxx/migrate/driver/sqlite"
 )
 
-// Up applies all available migrations
+// Up applies all available migrations. See SetAllowOutOfOrder for
+// migrations older than the current version.
 func Up(pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
-	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath, txnType)
+	UpContext(context.Background(), pipe, url, migrationsPath, txnType)
+}
+
+// UpContext is like Up, but stops once ctx is done.
+func UpContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
+	up(ctx, pipe, url, pathSource(migrationsPath), txnType)
+}
+
+func up(ctx context.Context, pipe chan interface{}, url string, src source, txnType driver.TxnType) {
+	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(ctx, url, src, txnType)
 	if err != nil {
 		go pipep.Close(pipe, err)
 		return
 	}
 
-	applyMigrationFiles, err := files.ToLastFrom(version)
+	applyMigrationFiles, err := upFiles(ctx, d, files, version)
 	if err != nil {
 		if err2 := d.Close(); err2 != nil {
 			pipe <- err2
@@ -34,26 +54,11 @@ func Up(pipe chan interface{}, url, migrationsPath string, txnType driver.TxnTyp
 		return
 	}
 
-	if len(applyMigrationFiles) > 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err := d.Close(); err != nil {
-			pipe <- err
-		}
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		if err := d.Close(); err != nil {
-			pipe <- err
-		}
-		go pipep.Close(pipe, nil)
-		return
+	migrateFiles(ctx, d, applyMigrationFiles, pipe)
+	if err := d.Close(); err != nil {
+		pipe <- err
 	}
+	go pipep.Close(pipe, nil)
 }
 
 // UpSync is synchronous version of Up
@@ -66,13 +71,22 @@ func UpSync(url, migrationsPath string, txnType driver.TxnType) (err []error, ok
 
 // Down rolls back all migrations
 func Down(pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
-	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath, txnType)
+	DownContext(context.Background(), pipe, url, migrationsPath, txnType)
+}
+
+// DownContext is like Down, but stops once ctx is done.
+func DownContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
+	down(ctx, pipe, url, pathSource(migrationsPath), txnType)
+}
+
+func down(ctx context.Context, pipe chan interface{}, url string, src source, txnType driver.TxnType) {
+	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(ctx, url, src, txnType)
 	if err != nil {
 		go pipep.Close(pipe, err)
 		return
 	}
 
-	applyMigrationFiles, err := files.ToFirstFrom(version)
+	applyMigrationFiles, err := downFiles(ctx, d, files, version)
 	if err != nil {
 		if err2 := d.Close(); err2 != nil {
 			pipe <- err2
@@ -81,26 +95,11 @@ func Down(pipe chan interface{}, url, migrationsPath string, txnType driver.TxnT
 		return
 	}
 
-	if len(applyMigrationFiles) > 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, nil)
-		return
+	migrateFiles(ctx, d, applyMigrationFiles, pipe)
+	if err2 := d.Close(); err2 != nil {
+		pipe <- err2
 	}
+	go pipep.Close(pipe, nil)
 }
 
 // DownSync is synchronous version of Down
@@ -113,14 +112,12 @@ func DownSync(url, migrationsPath string, txnType driver.TxnType) (err []error,
 
 // Redo rolls back the most recently applied migration, then runs it again.
 func Redo(pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
-	pipe1 := pipep.New()
-	go Migrate(pipe1, url, migrationsPath, -1, txnType)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		go Migrate(pipe, url, migrationsPath, +1, txnType)
-	}
+	RedoContext(context.Background(), pipe, url, migrationsPath, txnType)
+}
+
+// RedoContext is like Redo, but stops once ctx is done.
+func RedoContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
+	redoReset(ctx, pipe, url, pathSource(migrationsPath), -1, txnType)
 }
 
 // RedoSync is synchronous version of Redo
@@ -133,14 +130,12 @@ func RedoSync(url, migrationsPath string, txnType driver.TxnType) (err []error,
 
 // Reset runs the down and up migration function
 func Reset(pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
-	pipe1 := pipep.New()
-	go Down(pipe1, url, migrationsPath, txnType)
-	if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-		go pipep.Close(pipe, nil)
-		return
-	} else {
-		go Up(pipe, url, migrationsPath, txnType)
-	}
+	ResetContext(context.Background(), pipe, url, migrationsPath, txnType)
+}
+
+// ResetContext is like Reset, but stops once ctx is done.
+func ResetContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, txnType driver.TxnType) {
+	redoReset(ctx, pipe, url, pathSource(migrationsPath), 0, txnType)
 }
 
 // ResetSync is synchronous version of Reset
@@ -153,12 +148,28 @@ func ResetSync(url, migrationsPath string, txnType driver.TxnType) (err []error,
 
 // Migrate applies relative +n/-n migrations
 func Migrate(pipe chan interface{}, url, migrationsPath string, relativeN int, txnType driver.TxnType) {
-	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath, txnType)
+	MigrateContext(context.Background(), pipe, url, migrationsPath, relativeN, txnType)
+}
+
+// MigrateContext is like Migrate, but stops once ctx is done.
+func MigrateContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, relativeN int, txnType driver.TxnType) {
+	migrate(ctx, pipe, url, pathSource(migrationsPath), relativeN, txnType)
+}
+
+func migrate(ctx context.Context, pipe chan interface{}, url string, src source, relativeN int, txnType driver.TxnType) {
+	migrateRelative(ctx, pipe, url, src, txnType, func(uint64) int { return relativeN })
+}
+
+// migrateRelative migrates by the number of steps relative returns for
+// the current version, which is read under the migration lock.
+func migrateRelative(ctx context.Context, pipe chan interface{}, url string, src source, txnType driver.TxnType, relative func(version uint64) int) {
+	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(ctx, url, src, txnType)
 	if err != nil {
 		go pipep.Close(pipe, err)
 		return
 	}
 
+	relativeN := relative(version)
 	applyMigrationFiles, err := files.From(version, relativeN)
 	if err != nil {
 		if err2 := d.Close(); err2 != nil {
@@ -168,25 +179,13 @@ func Migrate(pipe chan interface{}, url, migrationsPath string, relativeN int, t
 		return
 	}
 
-	if len(applyMigrationFiles) > 0 && relativeN != 0 {
-		for _, f := range applyMigrationFiles {
-			pipe1 := pipep.New()
-			go d.Migrate(f, pipe1)
-			if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
-				break
-			}
-		}
-		if err2 := d.Close(); err2 != nil {
-			pipe <- err2
-		}
-		go pipep.Close(pipe, nil)
-		return
+	if relativeN != 0 {
+		migrateFiles(ctx, d, applyMigrationFiles, pipe)
 	}
 	if err2 := d.Close(); err2 != nil {
 		pipe <- err2
 	}
 	go pipep.Close(pipe, nil)
-	return
 }
 
 // MigrateSync is synchronous version of Migrate
@@ -197,6 +196,140 @@ func MigrateSync(url, migrationsPath string, relativeN int, txnType driver.TxnTy
 	return err, len(err) == 0
 }
 
+// Goto migrates up or down to version. The steps are counted from the
+// current version once the migration lock is held.
+func Goto(pipe chan interface{}, url, migrationsPath string, version uint64, txnType driver.TxnType) {
+	GotoContext(context.Background(), pipe, url, migrationsPath, version, txnType)
+}
+
+// GotoContext is like Goto, but stops once ctx is done.
+func GotoContext(ctx context.Context, pipe chan interface{}, url, migrationsPath string, version uint64, txnType driver.TxnType) {
+	goTo(ctx, pipe, url, pathSource(migrationsPath), version, txnType)
+}
+
+func goTo(ctx context.Context, pipe chan interface{}, url string, src source, target uint64, txnType driver.TxnType) {
+	migrateRelative(ctx, pipe, url, src, txnType, func(version uint64) int {
+		return int(target) - int(version)
+	})
+}
+
+// GotoSync is synchronous version of Goto
+func GotoSync(url, migrationsPath string, version uint64, txnType driver.TxnType) (err []error, ok bool) {
+	pipe := pipep.New()
+	go Goto(pipe, url, migrationsPath, version, txnType)
+	err = pipep.ReadErrors(pipe)
+	return err, len(err) == 0
+}
+
+// redoReset runs Redo (relativeN = -1) or Reset (relativeN = 0) on a
+// single driver, so that with driver.TxnSingle the down and the up half
+// share one transaction: if the up half fails, both are rolled back.
+func redoReset(ctx context.Context, pipe chan interface{}, url string, src source, relativeN int, txnType driver.TxnType) {
+	d, files, version, err := initDriverAndReadMigrationFilesAndGetVersion(ctx, url, src, txnType)
+	if err != nil {
+		go pipep.Close(pipe, err)
+		return
+	}
+
+	applyMigrationFiles, err := redoResetFiles(ctx, d, files, version, relativeN)
+	if err != nil {
+		if err2 := d.Close(); err2 != nil {
+			pipe <- err2
+		}
+		go pipep.Close(pipe, err)
+		return
+	}
+
+	migrateFiles(ctx, d, applyMigrationFiles, pipe)
+	if err := d.Close(); err != nil {
+		pipe <- err
+	}
+	go pipep.Close(pipe, nil)
+}
+
+// redoResetFiles returns the down files of Redo or Reset followed by
+// the up files. The up half is computed as if the down half had been
+// applied, as a transaction shared by both halves would hide it from
+// the version table until it commits.
+func redoResetFiles(ctx context.Context, d driver.Driver, files *file.MigrationFiles, version uint64, relativeN int) (file.Files, error) {
+	var downs, ups file.Files
+	var err error
+	if relativeN == 0 {
+		if downs, err = downFiles(ctx, d, files, version); err == nil {
+			ups, err = files.ToLastFrom(0)
+		}
+	} else {
+		if downs, err = files.From(version, relativeN); err == nil {
+			ups, err = files.From(previousVersion(*files, downs), -relativeN)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(downs, ups...), nil
+}
+
+// previousVersion returns the version that is current once the down
+// files have been applied.
+func previousVersion(files file.MigrationFiles, downs file.Files) uint64 {
+	lowest := uint64(0)
+	for _, f := range downs {
+		if !f.Always && (lowest == 0 || f.Version < lowest) {
+			lowest = f.Version
+		}
+	}
+	previous := uint64(0)
+	for _, mf := range files {
+		if !mf.Always && mf.Version < lowest && mf.Version > previous {
+			previous = mf.Version
+		}
+	}
+	return previous
+}
+
+// migrateFiles applies files one after another and redirects all output
+// to pipe. It stops after the first error, on interrupt or once ctx
+// is done. With DryRunPrint, the files are only sent to pipe.
+func migrateFiles(ctx context.Context, d driver.ContextDriver, files file.Files, pipe chan interface{}) (ok bool) {
+	if err := validateDirectives(d, files); err != nil {
+		pipe <- err
+		return false
+	}
+	if dryRun == DryRunPrint {
+		for _, f := range files {
+			pipe <- f
+		}
+		return true
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			pipe <- err
+			return false
+		}
+		pipe1 := pipep.New()
+		go d.MigrateContext(ctx, f, pipe1)
+		if ok := pipep.WaitAndRedirect(pipe1, pipe, handleInterrupts()); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validateDirectives checks the directives of all files before the first
+// one runs, so that a directive the driver does not support cannot stop
+// a run half way.
+func validateDirectives(d driver.Driver, files file.Files) error {
+	for i := range files {
+		if err := files[i].ReadContent(); err != nil {
+			return err
+		}
+		if _, err := driver.ReadDirectives(d, files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Version returns the current migration version
 func Version(url, migrationsPath string, txnType driver.TxnType) (version uint64, err error) {
 	d, err := driver.New(url, txnType)
@@ -206,6 +339,63 @@ func Version(url, migrationsPath string, txnType driver.TxnType) (version uint64
 	return d.Version()
 }
 
+// Force sets the current version and clears the dirty flag, after a
+// failed migration has been repaired by hand.
+func Force(url string, version uint64) error {
+	return ForceContext(context.Background(), url, version)
+}
+
+// ForceContext is like Force, but stops once ctx is done.
+func ForceContext(ctx context.Context, url string, version uint64) error {
+	d, err := driver.NewContext(ctx, url, driver.TxnNone)
+	if err != nil {
+		return err
+	}
+	dt, ok := driver.Unwrap(d).(driver.DirtyTracker)
+	if !ok {
+		d.Close()
+		return errors.New("driver does not support force")
+	}
+	if err := lockDriver(ctx, d); err != nil {
+		d.Close()
+		return err
+	}
+	if err := dt.Force(ctx, version); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Verify compares the checksums of the applied migrations with the up
+// files in migrationsPath and returns the files that have been edited
+// since they were applied.
+func Verify(url, migrationsPath string) ([]ChecksumMismatch, error) {
+	return VerifyContext(context.Background(), url, migrationsPath)
+}
+
+// VerifyContext is like Verify, but stops once ctx is done.
+func VerifyContext(ctx context.Context, url, migrationsPath string) ([]ChecksumMismatch, error) {
+	return verify(ctx, url, pathSource(migrationsPath))
+}
+
+func verify(ctx context.Context, url string, src source) ([]ChecksumMismatch, error) {
+	d, err := driver.NewContext(ctx, url, driver.TxnNone)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	hr, ok := driver.Unwrap(d).(driver.HistoryReader)
+	if !ok {
+		return nil, errors.New("driver does not record checksums")
+	}
+	files, err := src.readMigrationFiles(d)
+	if err != nil {
+		return nil, err
+	}
+	return compareChecksums(ctx, hr, files)
+}
+
 // Create creates new migration files on disk
 func Create(url, migrationsPath, name string, txnType driver.TxnType) (*file.MigrationFile, error) {
 	d, err := driver.New(url, txnType)
@@ -261,21 +451,143 @@ func Create(url, migrationsPath, name string, txnType driver.TxnType) (*file.Mig
 	return mfile, nil
 }
 
+// lockDriver takes the migration lock if the driver supports it.
+// The lock is released by closing the driver.
+func lockDriver(ctx context.Context, d driver.Driver) error {
+	if l, ok := driver.Unwrap(d).(driver.Locker); ok {
+		return l.Lock(ctx, driver.NewLockInfo(), lockTimeout)
+	}
+	return nil
+}
+
+// upgradeVersionTable converts the version table of older versions, if
+// the driver has to. It must only run under the lock.
+func upgradeVersionTable(ctx context.Context, d driver.Driver, files file.MigrationFiles) error {
+	if u, ok := driver.Unwrap(d).(driver.VersionTableUpgrader); ok {
+		return u.UpgradeVersionTable(ctx, files)
+	}
+	return nil
+}
+
+// checkDirty refuses to run against a database that a failed migration
+// left dirty, if the driver tracks it.
+func checkDirty(ctx context.Context, d driver.Driver) error {
+	dt, ok := driver.Unwrap(d).(driver.DirtyTracker)
+	if !ok {
+		return nil
+	}
+	version, dirty, err := dt.Dirty(ctx)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return &driver.DirtyError{Version: version}
+	}
+	return nil
+}
+
+// checkModified refuses to run if an applied up file has been edited
+// since, if the driver records checksums.
+func checkModified(ctx context.Context, d driver.Driver, files file.MigrationFiles) error {
+	hr, ok := driver.Unwrap(d).(driver.HistoryReader)
+	if !ok {
+		return nil
+	}
+	mismatches, err := compareChecksums(ctx, hr, files)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return &ModifiedError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// compareChecksums compares the checksums of the applied migrations with
+// their up files. Applied versions without a file or without a recorded
+// checksum are skipped.
+func compareChecksums(ctx context.Context, hr driver.HistoryReader, files file.MigrationFiles) ([]ChecksumMismatch, error) {
+	applied, err := hr.History(ctx)
+	if err != nil {
+		return nil, err
+	}
+	upFiles := make(map[uint64]file.File)
+	for _, mf := range files {
+		if !mf.Always && mf.UpFile != nil {
+			upFiles[mf.Version] = *mf.UpFile
+		}
+	}
+
+	mismatches := make([]ChecksumMismatch, 0)
+	for _, m := range applied {
+		f, ok := upFiles[m.Version]
+		if !ok || m.Checksum == "" {
+			continue
+		}
+		if err := f.ReadContent(); err != nil {
+			return nil, err
+		}
+		if checksum := f.Checksum(); checksum != m.Checksum {
+			mismatches = append(mismatches, ChecksumMismatch{
+				Version:  m.Version,
+				FileName: f.FileName,
+				Applied:  m.Checksum,
+				Current:  checksum,
+			})
+		}
+	}
+	return mismatches, nil
+}
+
 // initDriverAndReadMigrationFilesAndGetVersion is a small helper
-// function that is common to most of the migration funcs
-func initDriverAndReadMigrationFilesAndGetVersion(url, migrationsPath string, txnType driver.TxnType) (driver.Driver, *file.MigrationFiles, uint64, error) {
-	d, err := driver.New(url, txnType)
+// function that is common to most of the migration funcs. The migration
+// lock is taken, and the version table of older versions converted,
+// before the version is read. On error the driver is closed, and an
+// error from closing it is joined to the returned one. Dry runs are prepared here: DryRunPrint
+// never takes the lock, DryRunExecute runs in a single transaction that
+// is rolled back.
+func initDriverAndReadMigrationFilesAndGetVersion(ctx context.Context, url string, src source, txnType driver.TxnType) (_ driver.ContextDriver, _ *file.MigrationFiles, _ uint64, err error) {
+	if dryRun == DryRunExecute {
+		txnType = driver.TxnSingle
+	}
+	d, err := driver.NewContext(ctx, url, txnType)
 	if err != nil {
 		return nil, nil, 0, err
 	}
-	files, err := file.ReadMigrationFiles(migrationsPath, file.FilenameRegex(d.FilenameExtension()))
+	defer func() {
+		if err != nil {
+			if err2 := d.Close(); err2 != nil {
+				err = errors.Join(err, err2)
+			}
+		}
+	}()
+	if dryRun == DryRunExecute {
+		if err := rollbackOnClose(d); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	files, err := src.readMigrationFiles(d)
 	if err != nil {
-		d.Close() // TODO what happens with errors from this func?
 		return nil, nil, 0, err
 	}
-	version, err := d.Version()
+	if dryRun != DryRunPrint {
+		if err := lockDriver(ctx, d); err != nil {
+			return nil, nil, 0, err
+		}
+		if err := upgradeVersionTable(ctx, d, files); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if err := checkDirty(ctx, d); err != nil {
+		return nil, nil, 0, err
+	}
+	if !allowModified {
+		if err := checkModified(ctx, d, files); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	version, err := d.VersionContext(ctx)
 	if err != nil {
-		d.Close() // TODO what happens with errors from this func?
 		return nil, nil, 0, err
 	}
 	return d, &files, version, nil
@@ -287,6 +599,36 @@ func NewPipe() chan interface{} {
 	return pipep.New()
 }
 
+// lockTimeout is how long a run waits for the migration lock
+// held by another process.
+var lockTimeout = 5 * time.Minute
+
+// SetLockTimeout sets how long Up, Down, Migrate, Redo and Reset wait
+// for the migration lock if another process holds it. Zero means
+// fail immediately.
+func SetLockTimeout(timeout time.Duration) {
+	lockTimeout = timeout
+}
+
+// allowModified disables the checksum verification of applied files.
+var allowModified = false
+
+// SetAllowModified lets Up, Down, Migrate, Redo and Reset run although
+// up files have been edited after they were applied.
+func SetAllowModified(allow bool) {
+	allowModified = allow
+}
+
+// allowOutOfOrder lets Up apply versions below the current one.
+var allowOutOfOrder = false
+
+// SetAllowOutOfOrder lets Up apply migrations older than the most
+// recently applied one instead of refusing to run. It only has an effect
+// for drivers that record the applied versions.
+func SetAllowOutOfOrder(allow bool) {
+	allowOutOfOrder = allow
+}
+
 // interrupts is an internal variable that holds the state of
 // interrupt handling
 var interrupts = true
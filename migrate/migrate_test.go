@@ -1,19 +1,32 @@
 package migrate
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+	pipep "github.com/promoboxx/migrate/pipe"
 )
 
 // Add Driver URLs here to test basic Up, Down, .. functions.
-var driverUrls = []string{
-	"postgres://localhost/migratetest?sslmode=disable",
+// The sqlite database is created in a temporary directory of t.
+func driverUrls(t *testing.T) []string {
+	return []string{
+		"sqlite://" + path.Join(t.TempDir(), "migratetest.db"),
+		"postgres://localhost/migratetest?sslmode=disable",
+	}
 }
 
 func TestCreate(t *testing.T) {
-	for _, driverUrl := range driverUrls {
+	for _, driverUrl := range driverUrls(t) {
 		t.Logf("Test driver: %s", driverUrl)
 		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
 		if err != nil {
@@ -54,7 +67,7 @@ func TestCreate(t *testing.T) {
 }
 
 func TestReset(t *testing.T) {
-	for _, driverUrl := range driverUrls {
+	for _, driverUrl := range driverUrls(t) {
 		t.Logf("Test driver: %s", driverUrl)
 		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
 		if err != nil {
@@ -79,7 +92,7 @@ func TestReset(t *testing.T) {
 }
 
 func TestDown(t *testing.T) {
-	for _, driverUrl := range driverUrls {
+	for _, driverUrl := range driverUrls(t) {
 		t.Logf("Test driver: %s", driverUrl)
 		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
 		if err != nil {
@@ -116,7 +129,7 @@ func TestDown(t *testing.T) {
 }
 
 func TestUp(t *testing.T) {
-	for _, driverUrl := range driverUrls {
+	for _, driverUrl := range driverUrls(t) {
 		t.Logf("Test driver: %s", driverUrl)
 		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
 		if err != nil {
@@ -153,7 +166,7 @@ func TestUp(t *testing.T) {
 }
 
 func TestRedo(t *testing.T) {
-	for _, driverUrl := range driverUrls {
+	for _, driverUrl := range driverUrls(t) {
 		t.Logf("Test driver: %s", driverUrl)
 		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
 		if err != nil {
@@ -189,8 +202,34 @@ func TestRedo(t *testing.T) {
 	}
 }
 
+func TestRedoSingleTxn(t *testing.T) {
+	driverUrl, tmpdir, write := newSqliteFixture(t, "redo")
+	write("0001_a.up.sql", "CREATE TABLE a (id integer);")
+	write("0001_a.down.sql", "DROP TABLE a;")
+	// the down file leaves c behind, so running the up file again fails
+	write("0002_b.up.sql", "CREATE TABLE b (id integer); CREATE TABLE c (id integer);")
+	write("0002_b.down.sql", "DROP TABLE b;")
+
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnSingle); !ok {
+		t.Fatal(errs)
+	}
+
+	for _, run := range []func(string, string, driver.TxnType) ([]error, bool){RedoSync, ResetSync} {
+		if _, ok := run(driverUrl, tmpdir, driver.TxnSingle); ok {
+			t.Fatal("Expected the up half to fail")
+		}
+		version, err := Version(driverUrl, tmpdir, driver.TxnSingle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 2 {
+			t.Fatalf("Expected the down half to be rolled back to version 2, got %v", version)
+		}
+	}
+}
+
 func TestMigrate(t *testing.T) {
-	for _, driverUrl := range driverUrls {
+	for _, driverUrl := range driverUrls(t) {
 		t.Logf("Test driver: %s", driverUrl)
 		tmpdir, err := ioutil.TempDir("/tmp", "migrate-test")
 		if err != nil {
@@ -237,3 +276,341 @@ func TestMigrate(t *testing.T) {
 		}
 	}
 }
+
+func TestGoto(t *testing.T) {
+	driverUrl, tmpdir, write := newSqliteFixture(t, "goto")
+	for i, name := range []string{"0001_a", "0002_b", "0003_c"} {
+		write(name+".up.sql", fmt.Sprintf("CREATE TABLE t%d (id INTEGER);", i+1))
+		write(name+".down.sql", fmt.Sprintf("DROP TABLE t%d;", i+1))
+	}
+
+	for _, target := range []uint64{3, 1, 2, 0} {
+		if errs, ok := GotoSync(driverUrl, tmpdir, target, driver.TxnPerFile); !ok {
+			t.Fatal(errs)
+		}
+		version, err := Version(driverUrl, tmpdir, driver.TxnPerFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != target {
+			t.Fatalf("Expected version %v, got %v", target, version)
+		}
+	}
+}
+
+func TestForce(t *testing.T) {
+	driverUrl, tmpdir, _ := newSqliteFixture(t, "force")
+
+	mfile, err := Create(driverUrl, tmpdir, "migration1", driver.TxnNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upFile := path.Join(tmpdir, mfile.UpFile.FileName)
+	if err := ioutil.WriteFile(upFile, []byte("CREATE TABLE yolo (id integer); SELECT * FROM nonsense;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := UpSync(driverUrl, tmpdir, driver.TxnNone); ok {
+		t.Fatal("Expected up to fail")
+	}
+	errs, ok := UpSync(driverUrl, tmpdir, driver.TxnNone)
+	if ok {
+		t.Fatal("Expected up to refuse a dirty database")
+	}
+	if _, isDirty := errs[0].(*driver.DirtyError); !isDirty {
+		t.Fatalf("Expected *driver.DirtyError, got %v", errs)
+	}
+
+	// repair by hand, then force
+	if err := ioutil.WriteFile(upFile, []byte("CREATE TABLE IF NOT EXISTS yolo (id integer);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Force(driverUrl, 0); err != nil {
+		t.Fatal(err)
+	}
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnNone); !ok {
+		t.Fatal(errs)
+	}
+	version, err := Version(driverUrl, tmpdir, driver.TxnNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("Expected version 1, got %v", version)
+	}
+}
+
+func TestModified(t *testing.T) {
+	driverUrl, tmpdir, _ := newSqliteFixture(t, "modified")
+
+	mfile, err := Create(driverUrl, tmpdir, "migration1", driver.TxnPerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upFile := path.Join(tmpdir, mfile.UpFile.FileName)
+	if err := ioutil.WriteFile(upFile, []byte("CREATE TABLE yolo (id integer);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+	if mismatches, err := Verify(driverUrl, tmpdir); err != nil || len(mismatches) > 0 {
+		t.Fatalf("Expected no mismatches, got %v (%v)", mismatches, err)
+	}
+
+	if err := ioutil.WriteFile(upFile, []byte("CREATE TABLE yolo (id bigint);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := Verify(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatches) != 1 || mismatches[0].Version != 1 || mismatches[0].FileName != mfile.UpFile.FileName {
+		t.Fatalf("Expected a mismatch for version 1, got %v", mismatches)
+	}
+
+	errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile)
+	if ok {
+		t.Fatal("Expected up to refuse modified files")
+	}
+	if _, isModified := errs[0].(*ModifiedError); !isModified {
+		t.Fatalf("Expected *ModifiedError, got %v", errs)
+	}
+
+	SetAllowModified(true)
+	defer SetAllowModified(false)
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+}
+
+func TestOutOfOrder(t *testing.T) {
+	driverUrl, tmpdir, write := newSqliteFixture(t, "outoforder")
+	write("0001_a.up.sql", "CREATE TABLE a (id integer);")
+	write("0001_a.down.sql", "DROP TABLE a;")
+	write("0003_c.up.sql", "CREATE TABLE c (id integer);")
+	write("0003_c.down.sql", "DROP TABLE c;")
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+
+	// merged after version 3 has shipped
+	write("0002_b.up.sql", "CREATE TABLE b (id integer);")
+	write("0002_b.down.sql", "DROP TABLE b;")
+	errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile)
+	if ok {
+		t.Fatal("Expected up to refuse out of order migrations")
+	}
+	outOfOrderErr, isOutOfOrder := errs[0].(*OutOfOrderError)
+	if !isOutOfOrder {
+		t.Fatalf("Expected *OutOfOrderError, got %v", errs)
+	}
+	if len(outOfOrderErr.Missing) != 1 || outOfOrderErr.Missing[0].Version != 2 {
+		t.Fatalf("Expected version 2 to be missing, got %v", outOfOrderErr.Missing)
+	}
+
+	SetAllowOutOfOrder(true)
+	defer SetAllowOutOfOrder(false)
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+	if errs, ok := DownSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+	version, err := Version(driverUrl, tmpdir, driver.TxnPerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("Expected version 0, got %v", version)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	driverUrl, tmpdir, write := newSqliteFixture(t, "status")
+	write("0001_a.up.sql", "CREATE TABLE a (id integer);")
+	write("0001_a.down.sql", "DROP TABLE a;")
+	write("0002_b.up.sql", "CREATE TABLE b (id integer);")
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+	if err := os.Remove(path.Join(tmpdir, "0002_b.up.sql")); err != nil {
+		t.Fatal(err)
+	}
+	write("0003_c.up.sql", "CREATE TABLE c (id integer);")
+	write("0004_views.alwaysup.sql", "SELECT 1;")
+
+	statuses, err := Status(driverUrl, tmpdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 4 {
+		t.Fatalf("Expected 4 versions, got %v", statuses)
+	}
+	if s := statuses[0]; s.Version != 1 || s.Name != "a" || !s.Applied || s.AppliedAt.IsZero() || !s.HasDown || s.NoFile {
+		t.Errorf("Unexpected status %+v", s)
+	}
+	if s := statuses[1]; s.Version != 2 || s.Name != "b" || !s.Applied || !s.NoFile {
+		t.Errorf("Unexpected status %+v", s)
+	}
+	if s := statuses[2]; s.Version != 3 || s.Applied || s.HasDown {
+		t.Errorf("Unexpected status %+v", s)
+	}
+	if s := statuses[3]; s.Version != 4 || !s.Always || !s.HasUp || s.Applied {
+		t.Errorf("Unexpected status %+v", s)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	driverUrl, tmpdir, write := newSqliteFixture(t, "dryrun")
+	write("0001_a.up.sql", "CREATE TABLE a (id integer);")
+	write("0002_b.up.sql", "CREATE TABLE b (id integer);")
+
+	defer SetDryRun(NoDryRun)
+	for _, mode := range []DryRunMode{DryRunPrint, DryRunExecute} {
+		SetDryRun(mode)
+		pipe := NewPipe()
+		go Up(pipe, driverUrl, tmpdir, driver.TxnPerFile)
+		names := []string{}
+		for item := range pipe {
+			switch item := item.(type) {
+			case error:
+				t.Fatal(item)
+			case file.File:
+				names = append(names, item.FileName)
+			}
+		}
+		if strings.Join(names, ",") != "0001_a.up.sql,0002_b.up.sql" {
+			t.Errorf("Unexpected files %v for mode %v", names, mode)
+		}
+
+		SetDryRun(NoDryRun)
+		version, err := Version(driverUrl, tmpdir, driver.TxnPerFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != 0 {
+			t.Fatalf("Expected version 0 after dry run %v, got %v", mode, version)
+		}
+	}
+
+	// redo and reset run on one driver, so both halves share the
+	// transaction that is rolled back
+	write("0002_b.down.sql", "DROP TABLE b;")
+	SetDryRun(NoDryRun)
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+	SetDryRun(DryRunPrint)
+	pipe := NewPipe()
+	go Redo(pipe, driverUrl, tmpdir, driver.TxnPerFile)
+	names := []string{}
+	for item := range pipe {
+		switch item := item.(type) {
+		case error:
+			t.Fatal(item)
+		case file.File:
+			names = append(names, item.FileName)
+		}
+	}
+	if strings.Join(names, ",") != "0002_b.down.sql,0002_b.up.sql" {
+		t.Errorf("Unexpected files %v for redo", names)
+	}
+	SetDryRun(DryRunExecute)
+	if errs, ok := RedoSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+
+	// execute proves the SQL actually works
+	write("0003_c.up.sql", "INSERT INTO nonsense (id) VALUES (1);")
+	SetDryRun(DryRunExecute)
+	if _, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); ok {
+		t.Fatal("Expected dry run execute to fail")
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	driverUrl, tmpdir, write := newSqliteFixture(t, "directives")
+	write("0001_a.up.sql", "-- migrate:no-transaction\nCREATE TABLE a (id integer);")
+	write("0002_b.up.sql", "-- migrate:statement_timeout=1s\nCREATE TABLE b (id integer);")
+
+	// sqlite has no statement timeout, so nothing runs
+	errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile)
+	if ok {
+		t.Fatal("Expected the unsupported directive to fail")
+	}
+	var migrationErr *driver.MigrationError
+	if !errors.As(errs[0], &migrationErr) || migrationErr.Version != 2 {
+		t.Errorf("Expected a *driver.MigrationError for version 2, got %v", errs)
+	}
+	version, err := Version(driverUrl, tmpdir, driver.TxnPerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Fatalf("Expected version 0, got %v", version)
+	}
+
+	write("0002_b.up.sql", "CREATE TABLE b (id integer);")
+	if errs, ok := UpSync(driverUrl, tmpdir, driver.TxnPerFile); !ok {
+		t.Fatal(errs)
+	}
+
+	// all files share the transaction of a single transaction run
+	write("0003_c.up.sql", "-- migrate:no-transaction\nCREATE TABLE c (id integer);")
+	if _, ok := UpSync(driverUrl, tmpdir, driver.TxnSingle); ok {
+		t.Fatal("Expected no-transaction to fail in a single transaction")
+	}
+}
+
+func TestFS(t *testing.T) {
+	driverUrl, _, _ := newSqliteFixture(t, "fs")
+
+	fsys := fstest.MapFS{
+		"0001_a.up.sql":   {Data: []byte("CREATE TABLE a (id integer);")},
+		"0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		"0002_b.up.sql":   {Data: []byte("CREATE TABLE b (id integer);")},
+		"0002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	}
+
+	pipe := NewPipe()
+	go UpFS(context.Background(), pipe, driverUrl, fsys, driver.TxnPerFile)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	statuses, err := StatusFS(context.Background(), driverUrl, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || !statuses[0].Applied || !statuses[1].Applied {
+		t.Fatalf("Expected both migrations to be applied, got %v", statuses)
+	}
+
+	pipe = NewPipe()
+	go MigrateFS(context.Background(), pipe, driverUrl, fsys, -1, driver.TxnPerFile)
+	if errs := pipep.ReadErrors(pipe); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	version, err := Version(driverUrl, "", driver.TxnPerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Fatalf("Expected version 1, got %v", version)
+	}
+}
+
+// newSqliteFixture returns the url of a new sqlite database, an empty
+// migrations directory and a function that writes a file to it.
+func newSqliteFixture(t *testing.T, name string) (string, string, func(name, content string)) {
+	t.Helper()
+	tmpdir := t.TempDir()
+	driverUrl := "sqlite://" + path.Join(t.TempDir(), name+".db")
+	write := func(name, content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path.Join(tmpdir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return driverUrl, tmpdir, write
+}
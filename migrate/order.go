@@ -0,0 +1,76 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+)
+
+// OutOfOrderError is returned by Up if migrations older than the most
+// recently applied one have not been applied yet, e.g. because a branch
+// with a lower version was merged after a higher one shipped.
+// See SetAllowOutOfOrder.
+type OutOfOrderError struct {
+	Version uint64
+	Missing file.Files
+}
+
+func (e *OutOfOrderError) Error() string {
+	names := make([]string, 0, len(e.Missing))
+	for _, f := range e.Missing {
+		names = append(names, "  "+f.FileName)
+	}
+	return fmt.Sprintf("%v migration(s) older than the current version %v have not been applied:\n%s\n"+
+		"Run with -allow-out-of-order to apply them.", len(e.Missing), e.Version, strings.Join(names, "\n"))
+}
+
+// appliedVersions returns the set of applied versions, if the driver
+// records it. ok is false otherwise.
+func appliedVersions(ctx context.Context, d driver.Driver) (applied map[uint64]bool, ok bool, err error) {
+	hr, ok := driver.Unwrap(d).(driver.HistoryReader)
+	if !ok {
+		return nil, false, nil
+	}
+	history, err := hr.History(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	applied = make(map[uint64]bool, len(history))
+	for _, m := range history {
+		applied[m.Version] = true
+	}
+	return applied, true, nil
+}
+
+// upFiles returns the files Up applies. Drivers that record the applied
+// set get the versions that were skipped, or an *OutOfOrderError unless
+// out of order migrations are allowed.
+func upFiles(ctx context.Context, d driver.Driver, files *file.MigrationFiles, version uint64) (file.Files, error) {
+	applied, ok, err := appliedVersions(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return files.ToLastFrom(version)
+	}
+	if missing := files.Missing(version, applied); len(missing) > 0 && !allowOutOfOrder {
+		return nil, &OutOfOrderError{Version: version, Missing: missing}
+	}
+	return files.Pending(applied)
+}
+
+// downFiles returns the files Down applies. Drivers that record the
+// applied set only roll back versions that have actually been applied.
+func downFiles(ctx context.Context, d driver.Driver, files *file.MigrationFiles, version uint64) (file.Files, error) {
+	applied, ok, err := appliedVersions(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return files.ToFirstFrom(version)
+	}
+	return files.Applied(applied)
+}
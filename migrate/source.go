@@ -0,0 +1,68 @@
+package migrate
+
+import (
+	"context"
+	"io/fs"
+
+	"github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+)
+
+// source is where the migration files are read from: a directory on disk
+// or a fs.FS.
+type source struct {
+	path string
+	fsys fs.FS
+}
+
+func pathSource(migrationsPath string) source {
+	return source{path: migrationsPath}
+}
+
+func (s source) readMigrationFiles(d driver.Driver) (file.MigrationFiles, error) {
+	if s.fsys != nil {
+		return file.ReadMigrationFilesFS(s.fsys, file.FilenameRegex(d.FilenameExtension()))
+	}
+	return file.ReadMigrationFiles(s.path, file.FilenameRegex(d.FilenameExtension()))
+}
+
+// UpFS is like UpContext, but reads the migration files from the root of
+// fsys, e.g. an embed.FS. Use fs.Sub for files in a subdirectory.
+func UpFS(ctx context.Context, pipe chan interface{}, url string, fsys fs.FS, txnType driver.TxnType) {
+	up(ctx, pipe, url, source{fsys: fsys}, txnType)
+}
+
+// DownFS is like DownContext, but reads the migration files from fsys.
+func DownFS(ctx context.Context, pipe chan interface{}, url string, fsys fs.FS, txnType driver.TxnType) {
+	down(ctx, pipe, url, source{fsys: fsys}, txnType)
+}
+
+// RedoFS is like RedoContext, but reads the migration files from fsys.
+func RedoFS(ctx context.Context, pipe chan interface{}, url string, fsys fs.FS, txnType driver.TxnType) {
+	redoReset(ctx, pipe, url, source{fsys: fsys}, -1, txnType)
+}
+
+// ResetFS is like ResetContext, but reads the migration files from fsys.
+func ResetFS(ctx context.Context, pipe chan interface{}, url string, fsys fs.FS, txnType driver.TxnType) {
+	redoReset(ctx, pipe, url, source{fsys: fsys}, 0, txnType)
+}
+
+// MigrateFS is like MigrateContext, but reads the migration files from fsys.
+func MigrateFS(ctx context.Context, pipe chan interface{}, url string, fsys fs.FS, relativeN int, txnType driver.TxnType) {
+	migrate(ctx, pipe, url, source{fsys: fsys}, relativeN, txnType)
+}
+
+// GotoFS is like GotoContext, but reads the migration files from fsys.
+func GotoFS(ctx context.Context, pipe chan interface{}, url string, fsys fs.FS, version uint64, txnType driver.TxnType) {
+	goTo(ctx, pipe, url, source{fsys: fsys}, version, txnType)
+}
+
+// StatusFS is like StatusContext, but reads the migration files from fsys.
+func StatusFS(ctx context.Context, url string, fsys fs.FS) ([]MigrationStatus, error) {
+	return status(ctx, url, source{fsys: fsys})
+}
+
+// VerifyFS is like VerifyContext, but reads the migration files from fsys.
+func VerifyFS(ctx context.Context, url string, fsys fs.FS) ([]ChecksumMismatch, error) {
+	return verify(ctx, url, source{fsys: fsys})
+}
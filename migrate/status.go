@@ -0,0 +1,113 @@
+package migrate
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/promoboxx/migrate/driver"
+	"github.com/promoboxx/migrate/file"
+)
+
+// MigrationStatus describes one version, as found on disk and in the
+// database.
+type MigrationStatus struct {
+	Version uint64
+	Name    string
+
+	// Applied is set for applied versions. AppliedAt is zero if the
+	// driver does not record it, or the version was applied by an
+	// older version of migrate.
+	Applied   bool
+	AppliedAt time.Time
+
+	// Always marks alwaysup and alwaysdown files, which run on every
+	// up or down and are never recorded as applied.
+	Always bool
+
+	HasUp   bool
+	HasDown bool
+
+	// NoFile is set for applied versions without a file on disk.
+	NoFile bool
+}
+
+// Status lists the migration files in migrationsPath and the applied
+// versions, ordered by version.
+func Status(url, migrationsPath string) ([]MigrationStatus, error) {
+	return StatusContext(context.Background(), url, migrationsPath)
+}
+
+// StatusContext is like Status, but stops once ctx is done.
+func StatusContext(ctx context.Context, url, migrationsPath string) ([]MigrationStatus, error) {
+	return status(ctx, url, pathSource(migrationsPath))
+}
+
+func status(ctx context.Context, url string, src source) ([]MigrationStatus, error) {
+	d, err := driver.NewContext(ctx, url, driver.TxnNone)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	files, err := src.readMigrationFiles(d)
+	if err != nil {
+		return nil, err
+	}
+	history, err := readHistory(ctx, d, files)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[uint64]*MigrationStatus)
+	for _, mf := range files {
+		s := &MigrationStatus{
+			Version: mf.Version,
+			Always:  mf.Always,
+			HasUp:   mf.UpFile != nil,
+			HasDown: mf.DownFile != nil,
+		}
+		if mf.UpFile != nil {
+			s.Name = mf.UpFile.Name
+		} else if mf.DownFile != nil {
+			s.Name = mf.DownFile.Name
+		}
+		statuses[mf.Version] = s
+	}
+	for _, m := range history {
+		s, ok := statuses[m.Version]
+		if !ok {
+			s = &MigrationStatus{Version: m.Version, Name: m.Name, NoFile: true}
+			statuses[m.Version] = s
+		}
+		s.Applied = true
+		s.AppliedAt = m.AppliedAt
+	}
+
+	list := make([]MigrationStatus, 0, len(statuses))
+	for _, s := range statuses {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// readHistory returns the applied migrations. For drivers that only
+// know the current version, all versions of files up to it are taken
+// as applied.
+func readHistory(ctx context.Context, d driver.ContextDriver, files file.MigrationFiles) ([]driver.AppliedMigration, error) {
+	if hr, ok := driver.Unwrap(d).(driver.HistoryReader); ok {
+		return hr.History(ctx)
+	}
+	version, err := d.VersionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]driver.AppliedMigration, 0)
+	for _, mf := range files {
+		if !mf.Always && mf.Version <= version {
+			history = append(history, driver.AppliedMigration{Version: mf.Version})
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,99 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// archiveExtensions are the extensions readArchive understands.
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// archiveExtension returns the archive extension of name, or "".
+func archiveExtension(name string) string {
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// readArchive returns the regular files of the archive data that are
+// directly in dir. ext is one of archiveExtensions.
+func readArchive(data []byte, ext, dir string) (memFS, error) {
+	switch ext {
+	case ".zip":
+		return readZip(data, dir)
+	case ".tar":
+		return readTar(bytes.NewReader(data), dir)
+	default:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		return readTar(gz, dir)
+	}
+}
+
+func readTar(r io.Reader, dir string) (memFS, error) {
+	files := make(memFS)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name, ok := inDir(hdr.Name, dir)
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if files[name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func readZip(data []byte, dir string) (memFS, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	files := make(memFS)
+	for _, f := range zr.File {
+		name, ok := inDir(f.Name, dir)
+		if !ok || !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		files[name] = content
+	}
+	return files, nil
+}
+
+// inDir returns the base name of the archive entry name if it is
+// directly in dir. Entries may start with "./" or "/".
+func inDir(name, dir string) (string, bool) {
+	name = path.Clean("/" + name)[1:]
+	if name == "" || path.Dir(name) != dir {
+		return "", false
+	}
+	return path.Base(name), true
+}
@@ -0,0 +1,63 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// openGit clones the repository of u with the git command and reads dir
+// at the revision of the ref parameter, which defaults to the default
+// branch. It returns the commit that was read.
+func openGit(ctx context.Context, u *neturl.URL, dir string) (memFS, string, error) {
+	repo := *u
+	repo.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	repo.RawQuery = ""
+	ref := u.Query().Get("ref")
+	if strings.HasPrefix(ref, "-") {
+		return nil, "", fmt.Errorf("invalid ref %q in git source", ref)
+	}
+
+	tmp, err := os.MkdirTemp("", "migrate-source")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	if _, err := git(ctx, "", "clone", "--quiet", "--", repo.String(), tmp); err != nil {
+		return nil, "", err
+	}
+	if ref != "" {
+		if _, err := git(ctx, tmp, "checkout", "--quiet", ref, "--"); err != nil {
+			return nil, "", err
+		}
+	}
+	commit, err := git(ctx, tmp, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, "", err
+	}
+
+	files, err := readDir(filepath.Join(tmp, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, "", err
+	}
+	return files, commit, nil
+}
+
+// git runs the git command in dir and returns its trimmed output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
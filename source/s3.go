@@ -0,0 +1,109 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io"
+	neturl "net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// openS3 reads the objects directly below the prefix of u, or the archive
+// object u points to. Credentials come from the standard AWS credential
+// providers. The parameters are:
+//
+//	region      the region of the bucket, defaults to the AWS configuration
+//	endpoint    an S3 compatible endpoint, e.g. http://localhost:9000
+//	path-style  true addresses the bucket in the path, as many stand-ins need
+func openS3(ctx context.Context, u *neturl.URL, dir string) (memFS, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" {
+		return nil, errors.New("missing bucket in s3 source")
+	}
+	client, err := s3Client(u.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	if ext := archiveExtension(key); ext != "" {
+		data, err := getObject(ctx, client, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		return readArchive(data, ext, dir)
+	}
+
+	prefix := key
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if dir != "." {
+		prefix += dir + "/"
+	}
+	files := make(memFS)
+	var getErr error
+	err = client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(object.Key), prefix)
+			if name == "" {
+				continue
+			}
+			if files[path.Base(name)], getErr = getObject(ctx, client, bucket, aws.StringValue(object.Key)); getErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, getErr
+}
+
+func s3Client(query neturl.Values) (*s3.S3, error) {
+	config := aws.Config{}
+	if region := query.Get("region"); region != "" {
+		config.Region = aws.String(region)
+	}
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+	if pathStyle := query.Get("path-style"); pathStyle != "" {
+		enabled, err := strconv.ParseBool(pathStyle)
+		if err != nil {
+			return nil, errors.New("path-style in s3 source must be true or false")
+		}
+		config.S3ForcePathStyle = aws.Bool(enabled)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess), nil
+}
+
+func getObject(ctx context.Context, client *s3.S3, bucket, key string) ([]byte, error) {
+	out, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
@@ -0,0 +1,162 @@
+// Package source resolves migration source urls into the migration files
+// they point to, e.g. a release bundle or a git revision.
+//
+// Supported urls:
+//
+//	file:///path/to/migrations           a directory
+//	file:///path/to/migrations.tar.gz    a .tar.gz, .tgz, .tar or .zip archive
+//	zip://path/to/migrations.zip         a zip archive, whatever its extension
+//	s3://bucket/prefix                   the objects directly below prefix
+//	s3://bucket/bundles/v1.2.3.tar.gz    an archive object
+//	git://host/repo?ref=v1.2.3           a git revision, also git+https, git+ssh
+//	                                     and git+file
+//
+// The path parameter selects a subdirectory of archives and repositories,
+// e.g. git+https://github.com/org/app?ref=v1.2.3&path=db/migrations. The
+// parameters of s3 urls are described in openS3.
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Source holds the migration files of a resolved source url.
+type Source struct {
+	// URL identifies what was read, e.g. with the commit a git ref
+	// pointed to. Passwords are redacted.
+	URL string
+
+	// Hash is the hex encoded SHA-256 over the names and contents of
+	// the files, to tell which revision of the files ran.
+	Hash string
+
+	// FS holds the migration files in its root directory.
+	FS fs.FS
+}
+
+// String returns the url and the hash of s, for the run output.
+func (s *Source) String() string {
+	return s.URL + " (sha256 " + s.Hash + ")"
+}
+
+// Open reads the migration files url points to into memory.
+func Open(ctx context.Context, url string) (*Source, error) {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := subdirectory(u.Query().Get("path"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files memFS
+	switch {
+	case u.Scheme == "file":
+		files, err = openFile(u.Host+u.Path, dir)
+	case u.Scheme == "zip":
+		files, err = openArchive(u.Host+u.Path, ".zip", dir)
+	case u.Scheme == "s3":
+		files, err = openS3(ctx, u, dir)
+	case u.Scheme == "git" || strings.HasPrefix(u.Scheme, "git+"):
+		var commit string
+		files, commit, err = openGit(ctx, u, dir)
+		if err == nil {
+			// the url names the commit, not a ref that may move
+			q := u.Query()
+			q.Set("ref", commit)
+			u.RawQuery = q.Encode()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported source %q, expected file, zip, s3 or git", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files in source %s", u.Redacted())
+	}
+	return &Source{URL: u.Redacted(), Hash: files.hash(), FS: files}, nil
+}
+
+// subdirectory cleans the path parameter. The root is ".".
+func subdirectory(dir string) (string, error) {
+	dir = path.Clean("/" + dir)[1:]
+	if dir == "" {
+		return ".", nil
+	}
+	if !fs.ValidPath(dir) {
+		return "", fmt.Errorf("invalid source path %q", dir)
+	}
+	return dir, nil
+}
+
+// openFile reads a directory, or an archive if the name says so.
+func openFile(name, dir string) (memFS, error) {
+	if name == "" {
+		return nil, errors.New("missing path in file source")
+	}
+	if ext := archiveExtension(name); ext != "" {
+		return openArchive(name, ext, dir)
+	}
+	return readDir(filepath.Join(name, filepath.FromSlash(dir)))
+}
+
+func openArchive(name, ext, dir string) (memFS, error) {
+	if name == "" {
+		return nil, errors.New("missing path in archive source")
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return readArchive(data, ext, dir)
+}
+
+// readDir reads the regular files in the directory name.
+func readDir(name string) (memFS, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	files := make(memFS)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(name, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = content
+	}
+	return files, nil
+}
+
+// hash is the hex encoded SHA-256 over a line with the name and the
+// SHA-256 of the content of every file, ordered by name.
+func (m memFS) hash() string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		sum := sha256.Sum256(m[name])
+		fmt.Fprintf(h, "%s %x\n", name, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,201 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var migrations = map[string]string{
+	"db/001_a.up.sql":   "CREATE TABLE a (id int);",
+	"db/001_a.down.sql": "DROP TABLE a;",
+	"README.md":         "not a migration",
+}
+
+func TestOpenFile(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	dir := filepath.Join(tmpdir, "dir")
+	for name, content := range migrations {
+		writeFile(t, filepath.Join(dir, filepath.FromSlash(name)), []byte(content))
+	}
+
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	for _, name := range sortedNames() {
+		tw.WriteHeader(&tar.Header{Name: "./" + name, Mode: 0644, Size: int64(len(migrations[name])), Typeflag: tar.TypeReg})
+		tw.Write([]byte(migrations[name]))
+	}
+	tw.Close()
+	gz.Close()
+	writeFile(t, filepath.Join(tmpdir, "bundle.tar.gz"), tgz.Bytes())
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for _, name := range sortedNames() {
+		w, _ := zw.Create(name)
+		w.Write([]byte(migrations[name]))
+	}
+	zw.Close()
+	writeFile(t, filepath.Join(tmpdir, "bundle.bin"), zipped.Bytes())
+
+	var hash string
+	for _, url := range []string{
+		"file://" + dir + "/db",
+		"file://" + dir + "?path=db",
+		"file://" + tmpdir + "/bundle.tar.gz?path=db",
+		"zip://" + tmpdir + "/bundle.bin?path=/db/",
+	} {
+		src, err := Open(context.Background(), url)
+		if err != nil {
+			t.Fatalf("%s: %v", url, err)
+		}
+		expectFiles(t, url, src, "001_a.down.sql", "001_a.up.sql")
+		if hash != "" && src.Hash != hash {
+			t.Errorf("%s: expected hash %s, got %s", url, hash, src.Hash)
+		}
+		hash = src.Hash
+	}
+
+	if _, err := Open(context.Background(), "file://"+tmpdir+"/bundle.tar.gz?path=nothing"); err == nil {
+		t.Error("expected an error for a source without files")
+	}
+	if _, err := Open(context.Background(), "ftp://host/migrations"); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestOpenGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	run := func(args ...string) string {
+		out, err := git(context.Background(), repo, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	run("init", "--quiet")
+	for name, content := range migrations {
+		writeFile(t, filepath.Join(repo, filepath.FromSlash(name)), []byte(content))
+	}
+	run("add", ".")
+	run("commit", "--quiet", "-m", "first")
+	run("tag", "v1")
+	commit := run("rev-parse", "HEAD")
+	writeFile(t, filepath.Join(repo, "db", "002_b.up.sql"), []byte("CREATE TABLE b (id int);"))
+	run("add", ".")
+	run("commit", "--quiet", "-m", "second")
+
+	src, err := Open(context.Background(), "git+file://"+repo+"?ref=v1&path=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFiles(t, "v1", src, "001_a.down.sql", "001_a.up.sql")
+	if !strings.Contains(src.URL, "ref="+commit) {
+		t.Errorf("expected the url to name commit %s, got %s", commit, src.URL)
+	}
+
+	src, err = Open(context.Background(), "git+file://"+repo+"?path=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFiles(t, "default branch", src, "001_a.down.sql", "001_a.up.sql", "002_b.up.sql")
+}
+
+func TestOpenS3(t *testing.T) {
+	objects := map[string]string{
+		"releases/v1/001_a.up.sql":   "CREATE TABLE a (id int);",
+		"releases/v1/001_a.down.sql": "DROP TABLE a;",
+		"releases/v1/old/000.up.sql": "not directly below the prefix",
+		"releases/v2/002_b.up.sql":   "CREATE TABLE b (id int);",
+	}
+
+	// a stand-in for S3 with path style addressing
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		if r.URL.Path == "/bucket" || r.URL.Path == "/bucket/" {
+			prefix := r.URL.Query().Get("prefix")
+			fmt.Fprint(w, `<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+			for k, content := range objects {
+				if strings.HasPrefix(k, prefix) && !strings.Contains(k[len(prefix):], "/") {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(content))
+				}
+			}
+			fmt.Fprint(w, `</ListBucketResult>`)
+			return
+		}
+		content, ok := objects[key]
+		if !ok {
+			http.Error(w, `<Error><Code>NoSuchKey</Code></Error>`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	src, err := Open(context.Background(), "s3://bucket/releases/v1?region=us-east-1&path-style=true&endpoint="+server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFiles(t, "s3", src, "001_a.down.sql", "001_a.up.sql")
+}
+
+func TestMemFS(t *testing.T) {
+	files := memFS{"001_a.up.sql": []byte("CREATE TABLE a (id int);"), "001_a.down.sql": []byte("DROP TABLE a;")}
+	if err := fstest.TestFS(files, "001_a.up.sql", "001_a.down.sql"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectFiles(t *testing.T, name string, src *Source, expected ...string) {
+	t.Helper()
+	entries, err := src.FS.(memFS).ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("%s: expected files %v, got %v", name, expected, names)
+	}
+}
+
+func writeFile(t *testing.T, name string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sortedNames() []string {
+	names := make([]string, 0, len(migrations))
+	for name := range migrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}